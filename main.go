@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,9 +14,17 @@ import (
 	"time"
 
 	"github.com/gptscript-ai/datasets/pkg/tools"
+	"github.com/gptscript-ai/datasets/pkg/util"
 )
 
-var tokenFromEnv = os.Getenv("GPTSCRIPT_DAEMON_TOKEN")
+var (
+	tokenFromEnv = os.Getenv("GPTSCRIPT_DAEMON_TOKEN")
+
+	// datasetLocks serializes changes to each dataset, while still letting reads run in parallel.
+	// Saves to a GPTScript workspace can't check the dataset revision atomically, so this lock is
+	// what keeps them from overwriting each other (see dataset.Dataset.Save).
+	datasetLocks util.KeyedRWMutex
+)
 
 func main() {
 	if os.Getenv("PORT") == "" {
@@ -22,11 +33,20 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /addElements", authenticatedHandler(tools.AddElements))
-	mux.HandleFunc("POST /getAllElements", authenticatedHandler(tools.GetAllElements))
-	mux.HandleFunc("POST /listElements", authenticatedHandler(tools.ListElements))
-	mux.HandleFunc("POST /getElement", authenticatedHandler(tools.GetElement))
+	mux.HandleFunc("POST /addElements", authenticatedHandler(writeLockedHandler(tools.AddElements)))
+	mux.HandleFunc("POST /removeElement", authenticatedHandler(writeLockedHandler(tools.RemoveElement)))
+	mux.HandleFunc("POST /replaceElement", authenticatedHandler(writeLockedHandler(tools.ReplaceElement)))
+	mux.HandleFunc("POST /renameElement", authenticatedHandler(writeLockedHandler(tools.RenameElement)))
+	mux.HandleFunc("POST /getAllElements", authenticatedHandler(readLockedHandler(tools.GetAllElements)))
+	mux.HandleFunc("POST /listElements", authenticatedHandler(readLockedHandler(tools.ListElements)))
+	mux.HandleFunc("POST /searchDataset", authenticatedHandler(readLockedHandler(tools.SearchDataset)))
+	mux.HandleFunc("POST /nearestNeighbors", authenticatedHandler(readLockedHandler(tools.NearestNeighbors)))
+	mux.HandleFunc("POST /findElements", authenticatedHandler(readLockedHandler(tools.FindElements)))
+	mux.HandleFunc("POST /getElementRange", authenticatedHandler(readLockedHandler(tools.GetElementRange)))
+	mux.HandleFunc("POST /getElement", authenticatedHandler(readLockedHandler(tools.GetElement)))
 	mux.HandleFunc("POST /listDatasets", authenticatedHandler(tools.ListDatasets))
+	mux.HandleFunc("POST /updateDataset", authenticatedHandler(writeLockedHandler(tools.UpdateDatasetMeta)))
+	mux.HandleFunc("POST /deleteDataset", authenticatedHandler(writeLockedHandler(tools.DeleteDataset)))
 	mux.HandleFunc("POST /outputFilter", authenticatedHandler(tools.OutputFilter))
 	mux.HandleFunc("GET /{$}", health)
 
@@ -70,3 +90,36 @@ func authenticatedHandler(next http.HandlerFunc) http.HandlerFunc {
 func authenticate(headers http.Header) bool {
 	return headers.Get("X-GPTScript-Daemon-Token") == tokenFromEnv
 }
+
+func writeLockedHandler(next http.HandlerFunc) http.HandlerFunc {
+	return datasetLockedHandler(datasetLocks.Lock, next)
+}
+
+func readLockedHandler(next http.HandlerFunc) http.HandlerFunc {
+	return datasetLockedHandler(datasetLocks.RLock, next)
+}
+
+// datasetLockedHandler holds the lock for the dataset named in the request body while next runs.
+// Requests without a dataset ID, such as adding elements to a new dataset, don't take a dataset lock.
+func datasetLockedHandler(lock func(string) func(), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req struct {
+			DatasetID string `json:"datasetID"`
+		}
+		// Invalid requests are rejected by the handler itself.
+		_ = json.Unmarshal(body, &req)
+
+		if workspaceID, err := util.GetWorkspaceID(r); err == nil && req.DatasetID != "" {
+			defer lock(workspaceID + "\x00" + req.DatasetID)()
+		}
+
+		next(w, r)
+	}
+}
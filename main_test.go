@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// blockingRequests runs requests whose handler signals when it starts, and then waits for the
+// request to be released.
+type blockingRequests struct {
+	started  chan string
+	releases sync.Map
+}
+
+func newBlockingRequests() *blockingRequests {
+	return &blockingRequests{started: make(chan string, 10)}
+}
+
+func (b *blockingRequests) handle(_ http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get("X-Test-Request")
+	release, _ := b.releases.Load(name)
+	b.started <- name
+	<-release.(chan struct{})
+}
+
+// serve runs the request in the background, and returns the functions that release it and that
+// wait for it to finish.
+func (b *blockingRequests) serve(handler http.HandlerFunc, name, workspaceID, body string) (func(), func()) {
+	release, done := make(chan struct{}), make(chan struct{})
+	b.releases.Store(name, release)
+
+	go func() {
+		defer close(done)
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Add("X-GPTScript-Env", "GPTSCRIPT_WORKSPACE_ID="+workspaceID)
+		r.Header.Set("X-Test-Request", name)
+		handler(httptest.NewRecorder(), r)
+	}()
+
+	return func() { close(release) }, func() { <-done }
+}
+
+// requireStarted checks that exactly the named requests start, in any order.
+func (b *blockingRequests) requireStarted(t *testing.T, names ...string) {
+	t.Helper()
+
+	var started []string
+	for range names {
+		select {
+		case name := <-b.started:
+			started = append(started, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v of %v started", started, names)
+		}
+	}
+	require.ElementsMatch(t, names, started)
+	b.requireBlocked(t)
+}
+
+// requireBlocked checks that no other request starts.
+func (b *blockingRequests) requireBlocked(t *testing.T) {
+	t.Helper()
+
+	select {
+	case name := <-b.started:
+		t.Fatalf("request %s started while its dataset was locked", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDatasetLockedHandlers(t *testing.T) {
+	b := newBlockingRequests()
+	write, read := writeLockedHandler(b.handle), readLockedHandler(b.handle)
+
+	// Changes to a dataset wait for the change before them, but changes to other datasets, to the
+	// same dataset in other workspaces, and requests without a dataset don't.
+	releaseFirst, waitFirst := b.serve(write, "first", "ws1", `{"datasetID":"gds://aaaaa"}`)
+	b.requireStarted(t, "first")
+	releaseSecond, waitSecond := b.serve(write, "second", "ws1", `{"datasetID":"gds://aaaaa"}`)
+	b.requireBlocked(t)
+
+	releaseOther, waitOther := b.serve(write, "other dataset", "ws1", `{"datasetID":"gds://bbbbb"}`)
+	releaseWorkspace, waitWorkspace := b.serve(write, "other workspace", "ws2", `{"datasetID":"gds://aaaaa"}`)
+	releaseNew, waitNew := b.serve(write, "new dataset", "ws1", `{}`)
+	b.requireStarted(t, "other dataset", "other workspace", "new dataset")
+
+	releaseFirst()
+	waitFirst()
+	b.requireStarted(t, "second")
+
+	// Reads wait for changes, but not for each other.
+	releaseRead, waitRead := b.serve(read, "read", "ws1", `{"datasetID":"gds://aaaaa"}`)
+	releaseOtherRead, waitOtherRead := b.serve(read, "other read", "ws1", `{"datasetID":"gds://aaaaa"}`)
+	b.requireBlocked(t)
+
+	releaseSecond()
+	waitSecond()
+	b.requireStarted(t, "read", "other read")
+
+	// Changes wait for reads.
+	releaseThird, waitThird := b.serve(write, "third", "ws1", `{"datasetID":"gds://aaaaa"}`)
+	b.requireBlocked(t)
+
+	releaseRead()
+	releaseOtherRead()
+	waitRead()
+	waitOtherRead()
+	b.requireStarted(t, "third")
+
+	for _, release := range []func(){releaseThird, releaseOther, releaseWorkspace, releaseNew} {
+		release()
+	}
+	for _, wait := range []func(){waitThird, waitOther, waitWorkspace, waitNew} {
+		wait()
+	}
+}
@@ -0,0 +1,209 @@
+package dataset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// blobFolder holds the contents of the elements of every dataset, keyed by their hash (see
+// blobName), so that identical contents are only stored once.
+const blobFolder = "blobs"
+
+// GarbageGracePeriod is a grace period for CollectGarbage that is far longer than any Save takes.
+const GarbageGracePeriod = time.Hour
+
+// modTimeStore is implemented by stores that can tell when a file was last written.
+type modTimeStore interface {
+	modTime(ctx context.Context, name string) (time.Time, error)
+}
+
+func blobFile(hash string) string {
+	return blobFolder + "/" + hash
+}
+
+// writeBlob stores the contents and returns their hash.
+func (m *Manager) writeBlob(ctx context.Context, contents []byte) (string, error) {
+	hash, err := m.blobName(ctx, contents)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.store.WriteFile(ctx, blobFile(hash), contents); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// blobName returns the hash that the contents are stored under. It is their SHA-256 hash, unless
+// the store is encrypted: anyone who can list the blobs could then tell whether a dataset contains
+// some known contents, so it is an HMAC with a key derived from the current encryption key instead.
+func (m *Manager) blobName(ctx context.Context, contents []byte) (string, error) {
+	e, _, ok := findStore[*EncryptedStore](m.store)
+	if !ok || e.keys == nil {
+		sum := sha256.Sum256(contents)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	key, err := e.blobNameKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(contents)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// renameBlobs stores the contents of every element again if the name of its blob has changed,
+// which happens when the encryption key changes. It must not run at the same time as a Save.
+func (m *Manager) renameBlobs(ctx context.Context) error {
+	datasets, err := m.ListDatasets(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, meta := range datasets {
+		d, err := m.GetDataset(ctx, meta.ID)
+		if err != nil {
+			return err
+		}
+
+		renamed := false
+		for name, element := range d.Elements {
+			if element.blob == "" {
+				continue
+			}
+
+			if element, err = d.loadElement(ctx, element); err != nil {
+				return err
+			}
+
+			hash, err := m.blobName(ctx, element.payload())
+			if err != nil {
+				return err
+			} else if hash == element.blob {
+				continue
+			}
+
+			// Save writes the contents of elements without a blob.
+			element.blob = ""
+			element.changed = true
+			d.Elements[name] = element
+			renamed = true
+		}
+
+		if renamed {
+			if err := d.Save(ctx); err != nil {
+				return fmt.Errorf("failed to save dataset %s: %w", d.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CollectGarbage deletes the blobs, search index segments, embeddings, and old element files that are no longer
+// referenced by any dataset, and that were last written at least gracePeriod ago. Save writes blobs before
+// the dataset that references them, so unless gracePeriod is longer than a Save takes, like
+// GarbageGracePeriod, CollectGarbage must not run at the same time as a Save in any process that shares
+// the store. Stores that can't tell when a file was written, like WorkspaceStore, only support a
+// gracePeriod of 0.
+func (m *Manager) CollectGarbage(ctx context.Context, gracePeriod time.Duration) error {
+	times, _, ok := findStore[modTimeStore](m.store)
+	if !ok && gracePeriod > 0 {
+		return errors.New("the dataset store can't tell when files were written, so it has no grace period for garbage")
+	}
+
+	files, err := m.store.ListFiles(ctx, datasetFolder)
+	if err != nil {
+		return fmt.Errorf("failed to list dataset files: %w", err)
+	}
+
+	// Mark every file that a dataset refers to.
+	var (
+		candidates []string
+		datasets   []Dataset
+	)
+	for _, file := range files {
+		if !isManifest(file) {
+			if strings.HasPrefix(file, datasetFolder+"/") {
+				candidates = append(candidates, file)
+			}
+			continue
+		}
+
+		d, err := m.readDataset(ctx, file)
+		if err != nil {
+			return err
+		}
+		datasets = append(datasets, d)
+	}
+
+	if m.records != nil {
+		records, err := m.records.store.listDatasetRecords(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list datasets: %w", err)
+		}
+
+		for _, record := range records {
+			d, err := m.readRecords(ctx, record.ID)
+			if err != nil {
+				return err
+			}
+			datasets = append(datasets, d)
+		}
+	}
+
+	referenced := make(map[string]struct{})
+	for _, d := range datasets {
+		referenced[searchIndexFile(d.ID)] = struct{}{}
+		referenced[searchIndexFolder(d.ID)] = struct{}{}
+		referenced[vectorsFile(d.ID)] = struct{}{}
+		for _, element := range d.Elements {
+			if element.blob != "" {
+				referenced[blobFile(element.blob)] = struct{}{}
+			} else if element.file != "" {
+				referenced[element.file] = struct{}{}
+			}
+		}
+	}
+
+	blobs, err := m.store.ListFiles(ctx, blobFolder+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	candidates = append(candidates, blobs...)
+
+	// Sweep the rest.
+	for _, file := range candidates {
+		if _, ok := referenced[file]; ok {
+			continue
+		}
+		if folder, _, ok := strings.Cut(file, ".index/"); ok {
+			if _, ok := referenced[folder+".index/"]; ok {
+				continue
+			}
+		}
+		if gracePeriod > 0 {
+			written, err := times.modTime(ctx, file)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to check when %s was written: %w", file, err)
+			} else if time.Since(written) < gracePeriod {
+				continue
+			}
+		}
+		if err := m.store.DeleteFile(ctx, file); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete unreferenced file %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
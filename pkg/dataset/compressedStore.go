@@ -0,0 +1,79 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+)
+
+// gzipHeader marks files that were compressed by a CompressedStore.
+var gzipHeader = []byte("\x00GDSgz")
+
+// CompressedStore gzip compresses files before writing them to the underlying Store. Files are
+// prefixed with a header, so that files written without compression can still be read.
+type CompressedStore struct {
+	Store
+}
+
+func NewCompressedStore(s Store) *CompressedStore {
+	return &CompressedStore{Store: s}
+}
+
+func (s *CompressedStore) Unwrap() Store {
+	return s.Store
+}
+
+func (s *CompressedStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.Store.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(ctx, name, data)
+}
+
+func (s *CompressedStore) WriteFile(ctx context.Context, name string, contents []byte) error {
+	data, err := s.encode(ctx, name, contents)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.WriteFile(ctx, name, data)
+}
+
+func (s *CompressedStore) encode(_ context.Context, name string, contents []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(gzipHeader[:len(gzipHeader):len(gzipHeader)])
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(contents); err != nil {
+		return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+	}
+
+	// Contents that don't compress well, like images, are stored as is.
+	if buf.Len() >= len(contents) && !bytes.HasPrefix(contents, gzipHeader) {
+		return contents, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *CompressedStore) decode(_ context.Context, name string, data []byte) ([]byte, error) {
+	compressed, ok := bytes.CutPrefix(data, gzipHeader)
+	if !ok {
+		return data, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	defer r.Close()
+
+	if data, err = io.ReadAll(r); err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", name, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package dataset
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// conditionalStore is implemented by stores that can write a file only if it hasn't changed since
+// it was read, which Save uses to replace a dataset manifest. WorkspaceStore doesn't implement it,
+// so saves to a GPTScript workspace rely on the daemon's dataset locks instead.
+type conditionalStore interface {
+	// readFileVersion returns the file along with its version, an opaque string that is different
+	// every time the file is written.
+	readFileVersion(ctx context.Context, name string) ([]byte, string, error)
+	// writeFileIfVersion writes the file if it is still at the version, or if the version is empty
+	// and the file doesn't exist. Otherwise, it returns ErrConflict.
+	writeFileIfVersion(ctx context.Context, name string, contents []byte, version string) error
+}
+
+// conditionalFiles is the conditionalStore that a Manager's store wraps, along with the codecs of
+// the stores that wrap it.
+type conditionalFiles struct {
+	store conditionalStore
+	codecs
+}
+
+// findConditionalFiles returns the conditional files of the store, or nil if the store can't write
+// files conditionally.
+func findConditionalFiles(s Store) *conditionalFiles {
+	store, cs, ok := findStore[conditionalStore](s)
+	if !ok {
+		return nil
+	}
+	return &conditionalFiles{store: store, codecs: cs}
+}
+
+func (c *conditionalFiles) readFile(ctx context.Context, name string) ([]byte, string, error) {
+	data, version, err := c.store.readFileVersion(ctx, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if data, err = c.decode(ctx, name, data); err != nil {
+		return nil, "", err
+	}
+	return data, version, nil
+}
+
+func (c *conditionalFiles) writeFile(ctx context.Context, name string, contents []byte, version string) error {
+	data, err := c.encode(ctx, name, contents)
+	if err != nil {
+		return err
+	}
+
+	return c.store.writeFileIfVersion(ctx, name, data, version)
+}
+
+// contentVersion is the version of a file for stores that don't keep versions themselves. Every
+// write of a dataset manifest changes its revision, so its contents are never written twice.
+func contentVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package dataset
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DetectContentType returns the MIME type of the contents. Besides the types that
+// http.DetectContentType recognizes, JSON is detected as application/json.
+func DetectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if strings.HasPrefix(contentType, "text/plain") && json.Valid(data) {
+		return "application/json"
+	}
+	return contentType
+}
+
+// IsText reports whether contents of the MIME type are text, which can be shown to an LLM as they
+// are. Images, PDFs and other binary types are not text.
+func IsText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml":
+		return true
+	}
+	return false
+}
+
+// GetContentType returns the MIME type of the element. For elements that were saved before content
+// types were recorded, it is detected from the contents, so the element must have been read with
+// GetElement or a similar method.
+func (e Element) GetContentType() string {
+	if e.ContentType != "" {
+		return e.ContentType
+	}
+	return DetectContentType(e.payload())
+}
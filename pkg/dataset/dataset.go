@@ -3,15 +3,31 @@ package dataset
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"sort"
+	"time"
 
-	"github.com/gptscript-ai/go-gptscript"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 )
 
+// ErrConflict is returned by Save when the dataset was changed by someone else after it was read.
+var ErrConflict = errors.New("dataset was modified concurrently")
+
 type ElementMeta struct {
 	Name        string `json:"name,omitempty"`
 	Description string `json:"description,omitempty"`
+	// Metadata holds arbitrary key/value pairs, such as where the element came from.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// ContentType is the MIME type of the contents, such as "image/png" or "application/json". If it
+	// is not set when the element is added, it is detected from the contents.
+	ContentType string `json:"contentType,omitempty"`
+	// CreatedAt and UpdatedAt are unset for elements saved before they were recorded.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// AddedBy is the tool or program that set the contents of the element, if it is known.
+	AddedBy string `json:"addedBy,omitempty"`
 }
 
 type Element struct {
@@ -19,6 +35,25 @@ type Element struct {
 	Index          int    `json:"index,omitempty"`
 	Contents       string `json:"contents,omitempty"`
 	BinaryContents []byte `json:"binaryContents,omitempty"`
+	// Embedding is an optional vector for the element, used by NearestNeighbors. It is stored with
+	// the element's metadata, so every element has its own, even if its contents are the same as
+	// those of another element.
+	Embedding []float32 `json:"embedding,omitempty"`
+
+	// blob is the hash of the stored contents of the element. It is empty until the element is saved.
+	blob string
+	// file is where the contents of an element saved by version 2 of the file format are stored.
+	file string
+	// binary is true when the stored contents belong in BinaryContents rather than Contents.
+	binary bool
+	// loaded is true when Contents and BinaryContents hold the contents of the element.
+	loaded bool
+	// changed is true when the element was added, replaced or renamed since the dataset was read.
+	changed bool
+	// storedIndex is the position of the element when the dataset was read.
+	storedIndex int
+	// storedKey is the key of the element's record when the dataset was read from the records.
+	storedKey string
 }
 
 // ElementNoIndex is used for returning data to the user, since the user does not care about the index.
@@ -32,49 +67,93 @@ type DatasetMeta struct {
 	ID          string `json:"id,omitempty"`
 	Name        string `json:"name,omitempty"`
 	Description string `json:"description,omitempty"`
+	// Labels are key/value pairs that datasets can be listed by.
+	Labels map[string]string `json:"labels,omitempty"`
+	// CreatedAt and UpdatedAt are unset for datasets saved before they were recorded.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// CreatedBy is the tool or program that created the dataset, if it is known.
+	CreatedBy string `json:"createdBy,omitempty"`
+	// Schema is an optional JSON Schema that the contents of elements must match. See SetSchema.
+	Schema json.RawMessage `json:"schema,omitempty"`
 }
 
 type Dataset struct {
 	m           *Manager
 	DatasetMeta `json:",inline"`
 	Elements    map[string]Element `json:"elements,omitempty"`
+
+	// revision is incremented every time the dataset is saved. It is used to detect concurrent changes.
+	revision int
+	// schema is Schema compiled. It is compiled when the first element is validated.
+	schema *jsonschema.Schema
+	// legacyVectors is true when the embeddings were read from a vectors file, which Save deletes.
+	legacyVectors bool
+	// fromRecords is true when the dataset is stored in the records of the store, rather than in a
+	// manifest file. Datasets are moved to the records when they are saved, if the store has them.
+	fromRecords bool
+	// removed holds the record keys of the elements that were removed since the dataset was read.
+	removed []string
 }
 
 func (d *Dataset) GetID() string {
 	return d.ID
 }
 
+func (d *Dataset) GetRevision() int {
+	return d.revision
+}
+
+func (d *Dataset) SetName(name string) {
+	d.Name = name
+}
+
+func (d *Dataset) SetDescription(description string) {
+	d.Description = description
+}
+
+func (d *Dataset) SetLabel(key, value string) {
+	if d.Labels == nil {
+		d.Labels = make(map[string]string)
+	}
+	d.Labels[key] = value
+}
+
+func (d *Dataset) DeleteLabel(key string) {
+	delete(d.Labels, key)
+}
+
 func (d *Dataset) GetLength() int {
 	return len(d.Elements)
 }
 
 func (d *Dataset) ListElements() []ElementMeta {
-	var elements []Element
-	for _, element := range d.Elements {
-		elements = append(elements, element)
-	}
-	sort.Slice(elements, func(i, j int) bool {
-		return elements[i].Index < elements[j].Index
-	})
+	return d.ListElementsPage(0, 0)
+}
 
+// ListElementsPage returns the metadata of up to limit elements, starting at position offset.
+// A limit of 0 means no limit.
+func (d *Dataset) ListElementsPage(offset, limit int) []ElementMeta {
 	var elementMetas []ElementMeta
-	for _, element := range elements {
+	for _, element := range page(d.sortedElements(), offset, limit) {
 		elementMetas = append(elementMetas, element.ElementMeta)
 	}
 	return elementMetas
 }
 
-func (d *Dataset) GetAllElements() []ElementNoIndex {
-	var elements []Element
-	for _, element := range d.Elements {
-		elements = append(elements, element)
-	}
-	sort.Slice(elements, func(i, j int) bool {
-		return elements[i].Index < elements[j].Index
-	})
+func (d *Dataset) GetAllElements(ctx context.Context) ([]ElementNoIndex, error) {
+	return d.GetElementsPage(ctx, 0, 0)
+}
 
+// GetElementsPage returns up to limit elements, starting at position offset. A limit of 0 means no limit.
+func (d *Dataset) GetElementsPage(ctx context.Context, offset, limit int) ([]ElementNoIndex, error) {
 	var noIndex []ElementNoIndex
-	for _, element := range elements {
+	for _, element := range page(d.sortedElements(), offset, limit) {
+		element, err := d.loadElement(ctx, element)
+		if err != nil {
+			return nil, err
+		}
+
 		noIndex = append(noIndex, ElementNoIndex{
 			ElementMeta:    element.ElementMeta,
 			Contents:       element.Contents,
@@ -82,16 +161,35 @@ func (d *Dataset) GetAllElements() []ElementNoIndex {
 		})
 	}
 
-	return noIndex
+	return noIndex, nil
 }
 
-func (d *Dataset) GetElement(name string) (Element, error) {
+// GetElementsByRange returns the elements at positions start through end, inclusive. If end is
+// past the last element, the elements through the last one are returned.
+func (d *Dataset) GetElementsByRange(ctx context.Context, start, end int) ([]Element, error) {
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid element range %d to %d", start, end)
+	}
+
+	var elements []Element
+	for _, element := range page(d.sortedElements(), start, end-start+1) {
+		element, err := d.loadElement(ctx, element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+
+	return elements, nil
+}
+
+func (d *Dataset) GetElement(ctx context.Context, name string) (Element, error) {
 	e, exists := d.Elements[name]
 	if !exists {
 		return Element{}, fmt.Errorf("element %s not found", name)
 	}
 
-	return e, nil
+	return d.loadElement(ctx, e)
 }
 
 func (d *Dataset) AddElement(e Element) error {
@@ -99,21 +197,241 @@ func (d *Dataset) AddElement(e Element) error {
 		return fmt.Errorf("element %s already exists", e.Name)
 	}
 
-	e.Index = len(d.Elements)
+	return d.setElement(e, len(d.Elements))
+}
+
+// ReplaceElement replaces the element with the same name, keeping its position. If there is no
+// such element, the element is added to the end of the dataset.
+func (d *Dataset) ReplaceElement(e Element) error {
+	index := len(d.Elements)
+	if existing, exists := d.Elements[e.Name]; exists {
+		index = existing.Index
+	}
+
+	return d.setElement(e, index)
+}
+
+// RemoveElement removes the element and moves the elements after it up by one position.
+func (d *Dataset) RemoveElement(name string) error {
+	removed, exists := d.Elements[name]
+	if !exists {
+		return fmt.Errorf("element %s not found", name)
+	}
+
+	delete(d.Elements, name)
+	if removed.storedKey != "" {
+		d.removed = append(d.removed, removed.storedKey)
+	}
+	for n, element := range d.Elements {
+		if element.Index > removed.Index {
+			element.Index--
+			d.Elements[n] = element
+		}
+	}
+	return nil
+}
+
+// RenameElement changes the name of an element, keeping its position and contents.
+func (d *Dataset) RenameElement(name, newName string) error {
+	e, exists := d.Elements[name]
+	if !exists {
+		return fmt.Errorf("element %s not found", name)
+	}
+	if newName == "" {
+		return fmt.Errorf("new name of element %s is required", name)
+	}
+	if _, exists := d.Elements[newName]; exists {
+		return fmt.Errorf("element %s already exists", newName)
+	}
+
+	delete(d.Elements, name)
+	e.Name = newName
+	e.UpdatedAt = now()
+	e.changed = true
+	d.Elements[newName] = e
+	return nil
+}
+
+func (d *Dataset) setElement(e Element, index int) error {
+	if e.Contents != "" && len(e.BinaryContents) > 0 {
+		return fmt.Errorf("element %s cannot have both contents and binaryContents", e.Name)
+	}
+	if err := d.validate(e); err != nil {
+		return err
+	}
+	if err := d.checkEmbedding(e); err != nil {
+		return err
+	}
+
+	if e.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(e.ContentType); err != nil {
+			return fmt.Errorf("invalid content type %q for element %s: %w", e.ContentType, e.Name, err)
+		}
+	} else if payload := e.payload(); len(payload) > 0 {
+		e.ContentType = DetectContentType(payload)
+	}
+
+	// The creation time of a replaced element is kept, and everything else describes the new contents.
+	e.UpdatedAt = now()
+	e.CreatedAt = e.UpdatedAt
+	if existing, exists := d.Elements[e.Name]; exists {
+		if existing.CreatedAt != nil {
+			e.CreatedAt = existing.CreatedAt
+		}
+		e.storedKey = existing.storedKey
+	}
+	e.AddedBy = d.m.source
+
+	e.Index = index
+	e.blob, e.file = "", ""
+	e.loaded = true
+	e.changed = true
 	d.Elements[e.Name] = e
 	return nil
 }
 
+// Save writes the dataset to the store. It returns ErrConflict if the dataset has been saved by
+// someone else since it was read, in which case the caller should read it again and retry.
+//
+// The check is atomic with the write for stores that keep records or can write conditionally, like
+// SQLiteStore, S3Store, LocalStore and MemoryStore. With other stores, like WorkspaceStore, a save
+// that starts between the check and the write of another can still overwrite it, so saves of the
+// same dataset must not run at the same time, which the daemon ensures with its dataset locks.
 func (d *Dataset) Save(ctx context.Context) error {
-	datasetJSON, err := json.Marshal(d)
-	if err != nil {
-		return fmt.Errorf("failed to marshal dataset: %w", err)
+	// Records are written with the revision check, so only manifests need to be checked first.
+	var version string
+	if d.m.records == nil {
+		revision, v, err := d.m.readRevision(ctx, d.ID)
+		if err != nil {
+			return err
+		} else if revision != d.revision {
+			return fmt.Errorf("failed to save dataset %s at revision %d, current revision is %d: %w", d.ID, d.revision, revision, ErrConflict)
+		}
+		version = v
+	}
+
+	// Write out the contents of any elements that haven't been stored as blobs yet. Blobs are never
+	// modified, so the manifest is written last and readers never see a manifest that points to
+	// missing contents.
+	var oldFiles []string
+	for name, element := range d.Elements {
+		if element.blob != "" {
+			continue
+		}
+
+		element, err := d.loadElement(ctx, element)
+		if err != nil {
+			return err
+		}
+
+		if element.file != "" {
+			oldFiles = append(oldFiles, element.file)
+		}
+
+		element.blob, element.file = "", ""
+		element.binary = len(element.BinaryContents) > 0
+		if element.blob, err = d.m.writeBlob(ctx, element.payload()); err != nil {
+			return fmt.Errorf("failed to write element %s: %w", name, err)
+		}
+
+		d.Elements[name] = element
+	}
+
+	// A dataset that is moved from its manifest to the records doesn't need the manifest anymore.
+	if d.m.records != nil && !d.fromRecords {
+		oldFiles = append(oldFiles, datasetFolder+"/"+idToFileName(d.ID))
+	}
+
+	updatedAt := d.UpdatedAt
+	d.revision++
+	d.UpdatedAt = now()
+	if err := d.write(ctx, d.revision-1, version); err != nil {
+		d.revision--
+		d.UpdatedAt = updatedAt
+		return err
 	}
 
-	if err := d.m.gptscriptClient.WriteFileInWorkspace(ctx, datasetFolder+"/"+idToFileName(d.ID), datasetJSON, gptscript.WriteFileInWorkspaceOptions{
-		WorkspaceID: d.m.workspaceID,
-	}); err != nil {
-		return fmt.Errorf("failed to write dataset file: %w", err)
+	for name, element := range d.Elements {
+		element.changed, element.storedIndex = false, element.Index
+		d.Elements[name] = element
+	}
+	d.removed = nil
+
+	if err := d.updateSearchIndex(ctx); err != nil {
+		return err
+	}
+
+	// The contents and embeddings of elements from older datasets have been moved now.
+	if d.legacyVectors {
+		oldFiles = append(oldFiles, vectorsFile(d.ID))
+		d.legacyVectors = false
+	}
+	for _, file := range oldFiles {
+		if err := d.m.store.DeleteFile(ctx, file); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete old element file %s: %w", file, err)
+		}
 	}
 	return nil
 }
+
+// write writes the dataset to the records of the store if it has them, or to its manifest, replacing
+// the dataset at previousRevision, whose manifest is at version.
+func (d *Dataset) write(ctx context.Context, previousRevision int, version string) error {
+	if d.m.records != nil {
+		return d.writeRecords(ctx, previousRevision)
+	}
+	return d.m.writeDataset(ctx, d, version)
+}
+
+// loadElement reads the contents of the element from the store if they haven't been read yet.
+func (d *Dataset) loadElement(ctx context.Context, e Element) (Element, error) {
+	if e.loaded {
+		return e, nil
+	}
+
+	e, err := d.m.loadContents(ctx, e)
+	if err != nil {
+		return Element{}, err
+	}
+
+	d.Elements[e.Name] = e
+	return e, nil
+}
+
+func (d *Dataset) sortedElements() []Element {
+	var elements []Element
+	for _, element := range d.Elements {
+		elements = append(elements, element)
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[i].Index < elements[j].Index
+	})
+	return elements
+}
+
+func page(elements []Element, offset, limit int) []Element {
+	offset = max(offset, 0)
+	if offset >= len(elements) {
+		return nil
+	}
+
+	elements = elements[offset:]
+	if limit > 0 && limit < len(elements) {
+		elements = elements[:limit]
+	}
+	return elements
+}
+
+// now returns the current time, for the timestamps of datasets and elements.
+func now() *time.Time {
+	t := time.Now().UTC()
+	return &t
+}
+
+// payload returns the bytes that are stored as the contents of the element.
+func (e Element) payload() []byte {
+	if len(e.BinaryContents) > 0 {
+		return e.BinaryContents
+	}
+	return []byte(e.Contents)
+}
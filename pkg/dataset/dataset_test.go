@@ -1,37 +1,347 @@
 package dataset
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/gptscript-ai/go-gptscript"
 	"github.com/stretchr/testify/require"
 )
 
 func TestDatasets(t *testing.T) {
+	m, err := NewLocalManager(t.TempDir())
+	require.NoError(t, err)
+
+	testDatasets(t, m)
+}
+
+func TestEncryptedDatasets(t *testing.T) {
+	t.Setenv(encryptionKeyEnvVar, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	m, err := NewLocalManager(t.TempDir())
+	require.NoError(t, err)
+
+	testDatasets(t, m)
+	require.NoError(t, m.RotateEncryptionKeys(context.Background()))
+}
+
+func TestDatasetsInMemory(t *testing.T) {
+	testDatasets(t, NewManagerWithStore(NewMemoryStore()))
+}
+
+func TestDatasetsInSQLite(t *testing.T) {
+	t.Setenv(encryptionKeyEnvVar, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "datasets.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	m, err := newManager(s)
+	require.NoError(t, err)
+
+	testDatasets(t, m)
+	require.NoError(t, m.RotateEncryptionKeys(context.Background()))
+
+	datasets, err := m.ListDatasets(context.Background(), nil)
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+
+	element, err := m.GetElement(context.Background(), datasets[0].ID, "file1")
+	require.NoError(t, err)
+	require.Equal(t, "This is dataset file 1", element.Contents)
+}
+
+func TestSQLiteManager(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(sqliteDirEnvVar, dir)
+
+	m, err := NewManager("directory:///workspace")
+	require.NoError(t, err)
+	require.NotNil(t, m.records)
+
+	// Every request for the workspace uses the same database.
+	other, err := NewManager("directory:///workspace")
+	require.NoError(t, err)
+	require.Equal(t, m.records.store, other.records.store)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.db"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestS3Manager(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3("datasets-bucket")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	t.Setenv(s3BucketEnvVar, "datasets-bucket")
+	t.Setenv(s3EndpointEnvVar, strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv(s3PrefixEnvVar, "gptscript/")
+	t.Setenv(s3RegionEnvVar, "us-east-1")
+	t.Setenv(s3InsecureEnvVar, "true")
+	t.Setenv("AWS_ACCESS_KEY_ID", "access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	m, err := NewManager("directory:///workspace")
+	require.NoError(t, err)
+
+	d, err := m.NewDataset(ctx, "s3", "")
+	require.NoError(t, err)
+
+	// The workspace has its own folder under the configured prefix.
+	key := "gptscript/" + workspaceKey("directory:///workspace") + "/" + datasetFolder + "/" + idToFileName(d.ID)
+	require.Contains(t, fake.objects, key)
+
+	other, err := NewManager("directory:///other")
+	require.NoError(t, err)
+	datasets, err := other.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, datasets)
+
+	t.Setenv(sqliteDirEnvVar, t.TempDir())
+	_, err = NewManager("directory:///workspace")
+	require.ErrorContains(t, err, "only one of")
+}
+
+func TestSQLiteRecords(t *testing.T) {
 	ctx := context.Background()
+	t.Setenv(encryptionKeyEnvVar, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "datasets.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	m, err := newManager(s)
+	require.NoError(t, err)
+
+	d, err := m.NewDataset(ctx, "records", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "a"}, Contents: "one"}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "b"}, Contents: "two"}))
+	require.NoError(t, d.Save(ctx))
+
+	// Datasets are stored in their own tables rather than as manifest files.
+	files, err := s.ListFiles(ctx, datasetFolder+"/")
+	require.NoError(t, err)
+	require.NotContains(t, files, datasetFolder+"/"+idToFileName(d.ID))
+
+	elementRecord := func(name string) []byte {
+		key, err := m.elementKey(ctx, d.ID, name)
+		require.NoError(t, err)
+
+		var data []byte
+		require.NoError(t, s.db.QueryRow(`SELECT record FROM elements WHERE dataset_id = ? AND name = ?`, d.ID, key).Scan(&data))
+		return data
+	}
+
+	// Records are encrypted with a random data key, so a rewritten record would be different.
+	a := elementRecord("a")
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "c"}, Contents: "three"}))
+	require.NoError(t, d.Save(ctx))
+	require.Equal(t, a, elementRecord("a"))
+
+	element, err := m.GetElement(ctx, d.ID, "c")
+	require.NoError(t, err)
+	require.Equal(t, "three", element.Contents)
+	require.Equal(t, 2, element.Index)
+
+	_, err = m.GetElement(ctx, d.ID, "missing")
+	require.ErrorContains(t, err, "element missing not found")
+	_, err = m.GetElement(ctx, "gds://00000", "a")
+	require.ErrorContains(t, err, "dataset gds://00000 not found")
+
+	metas, total, err := m.ListElementsPage(ctx, d.ID, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 3, total)
+	require.Equal(t, []string{"b"}, names(metas))
+
+	// Removing and renaming elements only touches the elements involved and their positions.
+	require.NoError(t, d.RemoveElement("a"))
+	require.NoError(t, d.RenameElement("c", "see"))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "see"}, names(d.ListElements()))
 
-	g, err := gptscript.NewGPTScript()
+	see, err := d.GetElement(ctx, "see")
 	require.NoError(t, err)
+	require.Equal(t, "three", see.Contents)
 
-	workspaceID, err := g.CreateWorkspace(ctx, "directory")
+	// Stale copies can't be saved.
+	stale, err := m.GetDataset(ctx, d.ID)
 	require.NoError(t, err)
-	t.Logf("workspace ID: %s", workspaceID)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "d"}}))
+	require.NoError(t, d.Save(ctx))
+	require.NoError(t, stale.AddElement(Element{ElementMeta: ElementMeta{Name: "e"}}))
+	require.ErrorIs(t, stale.Save(ctx), ErrConflict)
 
-	defer g.DeleteWorkspace(ctx, gptscript.DeleteWorkspaceOptions{
-		WorkspaceID: workspaceID,
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	require.Equal(t, "records", datasets[0].Name)
+
+	require.NoError(t, m.DeleteDataset(ctx, d.ID))
+	_, err = m.GetDataset(ctx, d.ID)
+	require.ErrorContains(t, err, "not found")
+
+	var count int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM elements`).Scan(&count))
+	require.Zero(t, count)
+}
+
+func TestEncryptedElementNames(t *testing.T) {
+	ctx := context.Background()
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv(encryptionKeyEnvVar, oldKey)
+
+	path := filepath.Join(t.TempDir(), "datasets.db")
+	s, err := NewSQLiteStore(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
 	})
 
-	m, err := NewManager(workspaceID)
+	m, err := newManager(s)
 	require.NoError(t, err)
 
-	m.workspaceID = workspaceID
+	elementNames := []string{"alice@example.com", "https://example.com/report.pdf"}
+	d, err := m.NewDataset(ctx, "people", "")
+	require.NoError(t, err)
+	for _, name := range elementNames {
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: name}, Contents: "about " + name}))
+	}
+	require.NoError(t, d.Save(ctx))
+
+	// The names of the elements can't be found anywhere in the database.
+	assertNamesAbsent := func() {
+		t.Helper()
+
+		db, err := sql.Open("sqlite", path)
+		require.NoError(t, err)
+		defer db.Close()
 
+		rows, err := db.Query(`SELECT name, record FROM elements`)
+		require.NoError(t, err)
+		defer rows.Close()
+
+		count := 0
+		for rows.Next() {
+			var (
+				key    string
+				record []byte
+			)
+			require.NoError(t, rows.Scan(&key, &record))
+			for _, name := range elementNames {
+				require.NotContains(t, key, name)
+				require.NotContains(t, string(record), name)
+			}
+			count++
+		}
+		require.NoError(t, rows.Err())
+		require.Equal(t, len(elementNames), count)
+	}
+	assertNamesAbsent()
+
+	element, err := m.GetElement(ctx, d.ID, "alice@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "about alice@example.com", element.Contents)
+
+	// Elements are still found after the encryption key changes, and are stored under their new
+	// keys once the keys are rotated.
+	t.Setenv(encryptionKeyEnvVar, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	t.Setenv(previousEncryptionKeysEnvVar, oldKey)
+	m, err = newManager(s)
+	require.NoError(t, err)
+
+	element, err = m.GetElement(ctx, d.ID, "alice@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "about alice@example.com", element.Contents)
+
+	require.NoError(t, m.RotateEncryptionKeys(ctx))
+	assertNamesAbsent()
+
+	key, err := m.elementKey(ctx, d.ID, "alice@example.com")
+	require.NoError(t, err)
+	record, found, err := s.readElementRecord(ctx, d.ID, key)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Zero(t, record.Position)
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, elementNames, names(d.ListElements()))
+}
+
+func TestManifestToRecords(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "datasets.db"))
+	require.NoError(t, err)
 	t.Cleanup(func() {
-		_ = m.gptscriptClient.DeleteWorkspace(ctx)
+		require.NoError(t, s.Close())
 	})
 
-	dataset, err := m.NewDataset(ctx)
+	// Hide the records, so the dataset is saved as a manifest like before the store had records.
+	files := NewManagerWithStore(struct{ Store }{s})
+	d, err := files.NewDataset(ctx, "manifest", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "a"}, Contents: "one"}))
+	require.NoError(t, d.Save(ctx))
+
+	m := NewManagerWithStore(s)
+	element, err := m.GetElement(ctx, d.ID, "a")
+	require.NoError(t, err)
+	require.Equal(t, "one", element.Contents)
+
+	metas, total, err := m.ListElementsPage(ctx, d.ID, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Equal(t, []string{"a"}, names(metas))
+
+	// Saving moves the dataset to the records.
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "b"}, Contents: "two"}))
+	require.NoError(t, d.Save(ctx))
+
+	_, err = s.ReadFile(ctx, datasetFolder+"/"+idToFileName(d.ID))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.True(t, d.fromRecords)
+	require.Equal(t, []string{"a", "b"}, names(d.ListElements()))
+
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+
+	require.NoError(t, m.CollectGarbage(ctx, 0))
+	element, err = m.GetElement(ctx, d.ID, "a")
+	require.NoError(t, err)
+	require.Equal(t, "one", element.Contents)
+}
+
+func testDatasets(t *testing.T, m Manager) {
+	t.Helper()
+	ctx := context.Background()
+
+	dataset, err := m.NewDataset(ctx, "", "")
 	require.NoError(t, err)
 	require.Equal(t, 0, dataset.GetLength())
 
@@ -73,17 +383,17 @@ func TestDatasets(t *testing.T) {
 	metas := dataset.ListElements()
 	require.Len(t, metas, 3)
 
-	oneElement, err := dataset.GetElement("file1")
+	oneElement, err := dataset.GetElement(ctx, "file1")
 	require.NoError(t, err)
 	require.Equal(t, "This is dataset file 1", oneElement.Contents)
 	require.Equal(t, 0, oneElement.Index)
 
-	twoElement, err := dataset.GetElement("file2")
+	twoElement, err := dataset.GetElement(ctx, "file2")
 	require.NoError(t, err)
 	require.Equal(t, "This is dataset file 2", twoElement.Contents)
 	require.Equal(t, 1, twoElement.Index)
 
-	binaryElement, err := dataset.GetElement("binary file")
+	binaryElement, err := dataset.GetElement(ctx, "binary file")
 	require.NoError(t, err)
 	require.Equal(t, []byte("binary contents"), binaryElement.BinaryContents)
 	require.Equal(t, 2, binaryElement.Index)
@@ -94,7 +404,831 @@ func TestDatasets(t *testing.T) {
 	require.Equal(t, "file2", elementMetas[1].Name)
 	require.Equal(t, "binary file", elementMetas[2].Name)
 
-	datasets, err := m.ListDatasets(ctx)
+	datasets, err := m.ListDatasets(ctx, nil)
 	require.NoError(t, err)
 	require.Len(t, datasets, 1)
 }
+
+func TestManifestLayout(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	d, err := m.NewDataset(ctx, "layout", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "text"}, Contents: "some text"}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "binary"}, BinaryContents: []byte{0, 1, 2}}))
+	require.NoError(t, d.Save(ctx))
+
+	// The manifest must not contain the element contents.
+	manifest, err := s.ReadFile(ctx, datasetFolder+"/"+idToFileName(d.ID))
+	require.NoError(t, err)
+	require.NotContains(t, string(manifest), "some text")
+
+	files, err := s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// Listing datasets only looks at manifests.
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []DatasetMeta{d.DatasetMeta}, datasets)
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	binary, err := d.GetElement(ctx, "binary")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0, 1, 2}, binary.BinaryContents)
+	require.Empty(t, binary.Contents)
+
+	elements, err := d.GetAllElements(ctx)
+	require.NoError(t, err)
+	require.Len(t, elements, 2)
+	require.Equal(t, "text", elements[0].Name)
+	require.Equal(t, "some text", elements[0].Contents)
+	require.Equal(t, "binary", elements[1].Name)
+	require.Equal(t, []byte{0, 1, 2}, elements[1].BinaryContents)
+}
+
+func TestLegacyDatasetFile(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	legacy := `{"id":"gds://abc12","name":"old","elements":{` +
+		`"first":{"name":"first","contents":"one"},` +
+		`"second":{"name":"second","index":1,"binaryContents":"dHdv"}}}`
+	require.NoError(t, s.WriteFile(ctx, "datasets/abc12.gds", []byte(legacy)))
+
+	d, err := m.GetDataset(ctx, "gds://abc12")
+	require.NoError(t, err)
+	require.Equal(t, "old", d.Name)
+	require.Equal(t, []ElementMeta{{Name: "first"}, {Name: "second"}}, d.ListElements())
+
+	second, err := d.GetElement(ctx, "second")
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), second.BinaryContents)
+
+	// Saving converts the dataset to the manifest layout.
+	require.NoError(t, d.Save(ctx))
+
+	manifest, err := s.ReadFile(ctx, "datasets/abc12.gds")
+	require.NoError(t, err)
+	require.NotContains(t, string(manifest), "dHdv")
+	require.Contains(t, string(manifest), `"version":4`)
+
+	d, err = m.GetDataset(ctx, "gds://abc12")
+	require.NoError(t, err)
+
+	first, err := d.GetElement(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, "one", first.Contents)
+	require.Equal(t, 0, first.Index)
+}
+
+func TestNewerDatasetVersion(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	require.NoError(t, s.WriteFile(ctx, "datasets/abc12.gds", []byte(`{"version":1000,"id":"gds://abc12"}`)))
+
+	_, err := m.GetDataset(ctx, "gds://abc12")
+	require.ErrorContains(t, err, "newer than the supported version")
+}
+
+func TestInvalidDatasetID(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	for _, id := range []string{"", "gds", "gds://", "gds://ABCDE", "gds://abc12/../x", "gds://abc123"} {
+		_, err := m.GetDataset(ctx, id)
+		require.ErrorContains(t, err, "not found")
+		require.ErrorContains(t, m.DeleteDataset(ctx, id), "not found")
+		_, err = m.GetElement(ctx, id, "element")
+		require.ErrorContains(t, err, "not found")
+		_, _, err = m.ListElementsPage(ctx, id, 0, 0)
+		require.ErrorContains(t, err, "not found")
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+
+	first, err := m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	second, err := m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, first.AddElement(Element{ElementMeta: ElementMeta{Name: "first"}}))
+	require.NoError(t, first.Save(ctx))
+
+	// The second copy is stale now, so saving it must not drop the first element.
+	require.NoError(t, second.AddElement(Element{ElementMeta: ElementMeta{Name: "second"}}))
+	require.ErrorIs(t, second.Save(ctx), ErrConflict)
+
+	second, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, first.GetRevision(), second.GetRevision())
+	require.NoError(t, second.AddElement(Element{ElementMeta: ElementMeta{Name: "second"}}))
+	require.NoError(t, second.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, names(d.ListElements()))
+}
+
+// racingStore runs race right after the first time a file is read to save it.
+type racingStore struct {
+	*MemoryStore
+	race func()
+}
+
+func (s *racingStore) readFileVersion(ctx context.Context, name string) ([]byte, string, error) {
+	data, version, err := s.MemoryStore.readFileVersion(ctx, name)
+	if race := s.race; race != nil {
+		s.race = nil
+		race()
+	}
+	return data, version, err
+}
+
+func TestSaveRace(t *testing.T) {
+	ctx := context.Background()
+	s := &racingStore{MemoryStore: NewMemoryStore()}
+	m := NewManagerWithStore(s)
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+
+	// Another save that happens between checking the revision and writing the manifest must not be lost.
+	s.race = func() {
+		other, err := m.GetDataset(ctx, d.ID)
+		require.NoError(t, err)
+		require.NoError(t, other.AddElement(Element{ElementMeta: ElementMeta{Name: "other"}}))
+		require.NoError(t, other.Save(ctx))
+	}
+
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "mine"}}))
+	require.ErrorIs(t, d.Save(ctx), ErrConflict)
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"other"}, names(d.ListElements()))
+}
+
+func TestVersion2ElementFiles(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	manifest := `{"version":2,"id":"gds://abc12","elements":[{"name":"first","file":"datasets/abc12/0123"}]}`
+	require.NoError(t, s.WriteFile(ctx, "datasets/abc12.gds", []byte(manifest)))
+	require.NoError(t, s.WriteFile(ctx, "datasets/abc12/0123", []byte("one")))
+
+	d, err := m.GetDataset(ctx, "gds://abc12")
+	require.NoError(t, err)
+
+	first, err := d.GetElement(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, "one", first.Contents)
+
+	// Saving moves the contents into a blob.
+	require.NoError(t, d.Save(ctx))
+	_, err = s.ReadFile(ctx, "datasets/abc12/0123")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	d, err = m.GetDataset(ctx, "gds://abc12")
+	require.NoError(t, err)
+
+	first, err = d.GetElement(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, "one", first.Contents)
+}
+
+func TestEncryptedBlobNames(t *testing.T) {
+	ctx := context.Background()
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv(encryptionKeyEnvVar, oldKey)
+
+	s := NewMemoryStore()
+	m, err := newManager(s)
+	require.NoError(t, err)
+
+	for range 2 {
+		d, err := m.NewDataset(ctx, "", "")
+		require.NoError(t, err)
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "secret"}, Contents: "secret contents"}))
+		require.NoError(t, d.Save(ctx))
+	}
+
+	// Identical contents are still stored once, but not under their plain hash.
+	sum := sha256.Sum256([]byte("secret contents"))
+	blobs, err := s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.NotEqual(t, blobFile(hex.EncodeToString(sum[:])), blobs[0])
+
+	// Rotating the key stores the blobs under new names.
+	t.Setenv(encryptionKeyEnvVar, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	t.Setenv(previousEncryptionKeysEnvVar, oldKey)
+	m, err = newManager(s)
+	require.NoError(t, err)
+	require.NoError(t, m.RotateEncryptionKeys(ctx))
+
+	rotated, err := s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, rotated, 1)
+	require.NotEqual(t, blobs, rotated)
+
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	for _, meta := range datasets {
+		d, err := m.GetDataset(ctx, meta.ID)
+		require.NoError(t, err)
+
+		element, err := d.GetElement(ctx, "secret")
+		require.NoError(t, err)
+		require.Equal(t, "secret contents", element.Contents)
+
+		results, err := d.Search(ctx, "secret", 0)
+		require.NoError(t, err)
+		require.Equal(t, []string{"secret"}, searchNames(results))
+	}
+}
+
+func TestBlobDeduplication(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	for _, name := range []string{"first", "second"} {
+		d, err := m.NewDataset(ctx, name, "")
+		require.NoError(t, err)
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "shared"}, Contents: "the same document"}))
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "own"}, Contents: "only in " + name}))
+		require.NoError(t, d.Save(ctx))
+	}
+
+	blobs, err := s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 3)
+
+	// Blobs that are still referenced survive garbage collection.
+	require.NoError(t, m.CollectGarbage(ctx, 0))
+	blobs, err = s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 3)
+
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, datasets, 2)
+	require.NoError(t, m.DeleteDataset(ctx, datasets[0].ID))
+	require.ErrorContains(t, m.DeleteDataset(ctx, datasets[0].ID), "not found")
+
+	_, err = m.GetDataset(ctx, datasets[0].ID)
+	require.ErrorContains(t, err, "not found")
+	_, err = s.ReadFile(ctx, searchIndexFile(datasets[0].ID))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// The contents of the deleted dataset's elements are left for garbage collection, which keeps
+	// them while they are younger than the grace period.
+	blobs, err = s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 3)
+
+	require.NoError(t, m.CollectGarbage(ctx, GarbageGracePeriod))
+	blobs, err = s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 3)
+
+	require.NoError(t, m.CollectGarbage(ctx, 0))
+	blobs, err = s.ListFiles(ctx, blobFolder+"/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 2)
+
+	d, err := m.GetDataset(ctx, datasets[1].ID)
+	require.NoError(t, err)
+	elements, err := d.GetAllElements(ctx)
+	require.NoError(t, err)
+	require.Len(t, elements, 2)
+}
+
+func TestChangeElements(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: name}, Contents: name}))
+	}
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, d.RemoveElement("b"))
+	require.ErrorContains(t, d.RemoveElement("b"), "not found")
+
+	require.NoError(t, d.RenameElement("c", "see"))
+	require.ErrorContains(t, d.RenameElement("a", "d"), "already exists")
+	require.ErrorContains(t, d.RenameElement("missing", "other"), "not found")
+
+	require.NoError(t, d.ReplaceElement(Element{ElementMeta: ElementMeta{Name: "a", Description: "replaced"}, Contents: "new a"}))
+	require.NoError(t, d.ReplaceElement(Element{ElementMeta: ElementMeta{Name: "e"}, Contents: "e"}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "see", "d", "e"}, names(d.ListElements()))
+	require.Equal(t, "replaced", d.ListElements()[0].Description)
+
+	// The positions stay dense, so new elements go at the end.
+	for i, meta := range d.ListElements() {
+		require.Equal(t, i, d.Elements[meta.Name].Index)
+	}
+
+	renamed, err := d.GetElement(ctx, "see")
+	require.NoError(t, err)
+	require.Equal(t, "c", renamed.Contents)
+
+	replaced, err := d.GetElement(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "new a", replaced.Contents)
+}
+
+func TestUpdateDatasetMeta(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+
+	d.SetName("pages")
+	d.SetDescription("pages scraped from the docs site")
+	require.NoError(t, d.Save(ctx))
+
+	datasets, err := m.ListDatasets(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	require.Equal(t, d.ID, datasets[0].ID)
+	require.Equal(t, "pages", datasets[0].Name)
+	require.Equal(t, "pages scraped from the docs site", datasets[0].Description)
+}
+
+func TestDatasetLabels(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	for _, run := range []string{"2026-10-17", "2026-10-18"} {
+		d, err := m.NewDataset(ctx, run, "")
+		require.NoError(t, err)
+		d.SetLabel("run", run)
+		d.SetLabel("team", "scrapers")
+		require.NoError(t, d.Save(ctx))
+	}
+
+	datasets, err := m.ListDatasets(ctx, map[string]string{"run": "2026-10-18"})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+	require.Equal(t, "2026-10-18", datasets[0].Name)
+	require.Equal(t, map[string]string{"run": "2026-10-18", "team": "scrapers"}, datasets[0].Labels)
+
+	datasets, err = m.ListDatasets(ctx, map[string]string{"team": "scrapers"})
+	require.NoError(t, err)
+	require.Len(t, datasets, 2)
+
+	d, err := m.GetDataset(ctx, datasets[0].ID)
+	require.NoError(t, err)
+	d.DeleteLabel("team")
+	require.NoError(t, d.Save(ctx))
+
+	datasets, err = m.ListDatasets(ctx, map[string]string{"team": "scrapers"})
+	require.NoError(t, err)
+	require.Len(t, datasets, 1)
+}
+
+func TestElementPages(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: name}, Contents: name}))
+	}
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"c", "d"}, names(d.ListElementsPage(2, 2)))
+	require.Equal(t, []string{"d", "e"}, names(d.ListElementsPage(3, 10)))
+	require.Equal(t, []string{"b", "c", "d", "e"}, names(d.ListElementsPage(1, 0)))
+	require.Empty(t, d.ListElementsPage(5, 1))
+
+	elements, err := d.GetElementsPage(ctx, 4, 2)
+	require.NoError(t, err)
+	require.Len(t, elements, 1)
+	require.Equal(t, "e", elements[0].Name)
+	require.Equal(t, "e", elements[0].Contents)
+
+	// Only the elements on the page are read.
+	require.False(t, d.Elements["a"].loaded)
+
+	inRange, err := d.GetElementsByRange(ctx, 1, 2)
+	require.NoError(t, err)
+	require.Len(t, inRange, 2)
+	require.Equal(t, "b", inRange[0].Contents)
+	require.Equal(t, 2, inRange[1].Index)
+
+	inRange, err = d.GetElementsByRange(ctx, 3, 100)
+	require.NoError(t, err)
+	require.Len(t, inRange, 2)
+
+	_, err = d.GetElementsByRange(ctx, 3, 2)
+	require.Error(t, err)
+}
+
+func TestFindElements(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	for _, meta := range []ElementMeta{
+		{Name: "docs/intro.md", Description: "Introduction"},
+		{Name: "docs/setup.md", Description: "How to install"},
+		{Name: "report-2026.pdf", Description: "Yearly report"},
+	} {
+		require.NoError(t, d.AddElement(Element{ElementMeta: meta}))
+	}
+
+	found, err := d.FindElements(ElementQuery{Glob: "docs/*"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs/intro.md", "docs/setup.md"}, names(found))
+
+	// Globs match the description too, and ignore case.
+	found, err = d.FindElements(ElementQuery{Glob: "*REPORT*"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"report-2026.pdf"}, names(found))
+
+	found, err = d.FindElements(ElementQuery{Regex: `install|intro`})
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs/intro.md", "docs/setup.md"}, names(found))
+
+	found, err = d.FindElements(ElementQuery{Glob: "*.md", Regex: `^How`})
+	require.NoError(t, err)
+	require.Equal(t, []string{"docs/setup.md"}, names(found))
+
+	_, err = d.FindElements(ElementQuery{Regex: `(`})
+	require.Error(t, err)
+}
+
+func TestElementMetadata(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{
+		Name:     "issue",
+		Metadata: map[string]string{"source": "github", "url": "https://github.com/gptscript-ai/datasets/issues/1"},
+	}}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{
+		Name:     "page",
+		Metadata: map[string]string{"source": "web"},
+	}}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.Equal(t, "github", d.ListElements()[0].Metadata["source"])
+
+	found, err := d.FindElements(ElementQuery{Metadata: map[string]string{"source": "github"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"issue"}, names(found))
+
+	found, err = d.FindElements(ElementQuery{Glob: "*", Metadata: map[string]string{"source": "web", "author": "jane"}})
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
+
+func TestTimestampsAndProvenance(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+	m.SetSource("scraper")
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NotNil(t, d.CreatedAt)
+	require.Equal(t, "scraper", d.CreatedBy)
+
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "page", AddedBy: "someone else"}, Contents: "v1"}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	added := d.ListElements()[0]
+	require.NotNil(t, added.CreatedAt)
+	require.Equal(t, "scraper", added.AddedBy)
+	require.False(t, d.UpdatedAt.Before(*d.CreatedAt))
+
+	m.SetSource("fixer")
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.NoError(t, d.ReplaceElement(Element{ElementMeta: ElementMeta{Name: "page"}, Contents: "v2"}))
+	require.NoError(t, d.Save(ctx))
+
+	replaced := d.ListElements()[0]
+	require.True(t, replaced.CreatedAt.Equal(*added.CreatedAt))
+	require.False(t, replaced.UpdatedAt.Before(*added.UpdatedAt))
+	require.Equal(t, "fixer", replaced.AddedBy)
+
+	older, newer := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	datasets := []DatasetMeta{{ID: "legacy"}, {ID: "older", UpdatedAt: &older}, {ID: "newer", UpdatedAt: &newer}}
+	SortByRecency(datasets)
+	require.Equal(t, []string{"newer", "older", "legacy"}, []string{datasets[0].ID, datasets[1].ID, datasets[2].ID})
+}
+
+func TestContentType(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "text"}, Contents: "plain text"}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "record"}, Contents: `{"id": 1}`}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "image"}, BinaryContents: []byte("\x89PNG\r\n\x1a\n")}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "page", ContentType: "text/markdown"}, Contents: "# Title"}))
+	require.Error(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "bad", ContentType: "not a type"}, Contents: "x"}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	var contentTypes []string
+	for _, meta := range d.ListElements() {
+		contentTypes = append(contentTypes, meta.ContentType)
+	}
+	require.Equal(t, []string{"text/plain; charset=utf-8", "application/json", "image/png", "text/markdown"}, contentTypes)
+
+	require.True(t, IsText("text/markdown"))
+	require.True(t, IsText("application/ld+json"))
+	require.False(t, IsText("image/png"))
+	require.False(t, IsText("application/pdf"))
+
+	// Elements saved before content types were recorded have theirs detected when they are read.
+	legacy := Element{ElementMeta: ElementMeta{Name: "legacy"}, BinaryContents: []byte("%PDF-1.7")}
+	require.Equal(t, "application/pdf", legacy.GetContentType())
+}
+
+func TestSchema(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.Error(t, d.SetSchema(ctx, []byte(`{"type": 1}`)))
+	require.Error(t, d.SetSchema(ctx, []byte(`{"$ref": "file:///etc/passwd"}`)))
+	require.NoError(t, d.SetSchema(ctx, []byte(`{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}}}`)))
+	require.NoError(t, d.Save(ctx))
+
+	// The schema is saved with the dataset.
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "valid"}, Contents: `{"id": 1}`}))
+	require.ErrorContains(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "malformed"}, Contents: `{"id": `}), "not valid JSON")
+	require.ErrorContains(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "wrong type"}, Contents: `{"id": "one"}`}), "/id")
+	require.ErrorContains(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "missing"}, Contents: `{}`}), "id")
+	require.Error(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "binary"}, BinaryContents: []byte{1}}))
+	require.Error(t, d.ReplaceElement(Element{ElementMeta: ElementMeta{Name: "valid"}, Contents: `[]`}))
+	require.Equal(t, []string{"valid"}, names(d.ListElements()))
+
+	require.NoError(t, d.SetSchema(ctx, nil))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "free"}, Contents: "anything"}))
+	require.NoError(t, d.Save(ctx))
+
+	// A schema that the existing elements don't match is rejected, and the dataset keeps its schema.
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.ErrorContains(t, d.SetSchema(ctx, []byte(`{"type": "object"}`)), "element free")
+	require.Empty(t, d.Schema)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "still free"}, Contents: "anything"}))
+
+	require.NoError(t, d.RemoveElement("free"))
+	require.NoError(t, d.RemoveElement("still free"))
+	require.NoError(t, d.SetSchema(ctx, []byte(`{"type": "object"}`)))
+}
+
+func names(metas []ElementMeta) []string {
+	var result []string
+	for _, meta := range metas {
+		result = append(result, meta.Name)
+	}
+	return result
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	for name, contents := range map[string]string{
+		"cats":    "Cats are small, carnivorous mammals. Cats sleep a lot.",
+		"dogs":    "Dogs are loyal mammals that were domesticated from wolves.",
+		"rockets": "Rockets carry satellites into orbit.",
+	} {
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: name}, Contents: contents}))
+	}
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "image"}, BinaryContents: []byte("cats")}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	results, err := d.Search(ctx, "cats", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "cats", results[0].Name)
+
+	results, err = d.Search(ctx, "Mammals CATS", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"cats", "dogs"}, searchNames(results))
+
+	results, err = d.Search(ctx, "mammals", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	// The index follows renames and removals.
+	require.NoError(t, d.RenameElement("dogs", "puppies"))
+	require.NoError(t, d.RemoveElement("cats"))
+	require.NoError(t, d.Save(ctx))
+
+	results, err = d.Search(ctx, "mammals", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"puppies"}, searchNames(results))
+
+	// A save writes a segment with only the documents it adds.
+	segments, err := s.ListFiles(ctx, searchIndexFolder(d.ID))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "birds"}, Contents: "Birds are not mammals."}))
+	require.NoError(t, d.Save(ctx))
+	segments, err = s.ListFiles(ctx, searchIndexFolder(d.ID))
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+
+	data, err := s.ReadFile(ctx, segments[0])
+	require.NoError(t, err)
+	if !strings.Contains(string(data), "birds") {
+		data, err = s.ReadFile(ctx, segments[1])
+		require.NoError(t, err)
+	}
+	require.Contains(t, string(data), "birds")
+	require.NotContains(t, string(data), "rockets")
+
+	// A save that conflicts with another doesn't change the index.
+	stale, err := m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "fish"}, Contents: "Fish swim."}))
+	require.NoError(t, d.Save(ctx))
+	require.NoError(t, stale.AddElement(Element{ElementMeta: ElementMeta{Name: "frogs"}, Contents: "Frogs jump."}))
+	require.ErrorIs(t, stale.Save(ctx), ErrConflict)
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	results, err = d.Search(ctx, "fish frogs", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fish"}, searchNames(results))
+
+	// Elements that are missing from the index are indexed when searching.
+	segments, err = s.ListFiles(ctx, searchIndexFolder(d.ID))
+	require.NoError(t, err)
+	for _, segment := range segments {
+		require.NoError(t, s.DeleteFile(ctx, segment))
+	}
+	results, err = d.Search(ctx, "mammals", 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"puppies", "birds"}, searchNames(results))
+
+	// The index survives garbage collection, and is deleted with the dataset.
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "whales"}, Contents: "Whales are mammals."}))
+	require.NoError(t, d.Save(ctx))
+	require.NoError(t, m.CollectGarbage(ctx, 0))
+	segments, err = s.ListFiles(ctx, searchIndexFolder(d.ID))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	require.NoError(t, m.DeleteDataset(ctx, d.ID))
+	segments, err = s.ListFiles(ctx, searchIndexFolder(d.ID))
+	require.NoError(t, err)
+	require.Empty(t, segments)
+}
+
+func TestSearchSegments(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	m := NewManagerWithStore(s)
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "removed"}, Contents: "carnivorous"}))
+	require.NoError(t, d.Save(ctx))
+	require.NoError(t, d.RemoveElement("removed"))
+
+	// Segments are merged once there are too many, without the documents of removed elements.
+	for i := range maxSearchSegments {
+		require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: strconv.Itoa(i)}, Contents: "element " + strconv.Itoa(i)}))
+		require.NoError(t, d.Save(ctx))
+
+		segments, err := s.ListFiles(ctx, searchIndexFolder(d.ID))
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(segments), maxSearchSegments)
+	}
+
+	index, err := m.readSearchIndex(ctx, d.ID)
+	require.NoError(t, err)
+	require.Len(t, index.Lengths, maxSearchSegments)
+	require.NotContains(t, index.Postings, "carnivorous")
+
+	results, err := d.Search(ctx, "element", 0)
+	require.NoError(t, err)
+	require.Len(t, results, maxSearchSegments)
+}
+
+func searchNames(results []SearchResult) []string {
+	var result []string
+	for _, r := range results {
+		result = append(result, r.Name)
+	}
+	return result
+}
+
+func TestNearestNeighbors(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "x"}, Contents: "x", Embedding: []float32{1, 0, 0}}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "y"}, Contents: "y", Embedding: []float32{0, 1, 0}}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "xy"}, Contents: "xy", Embedding: []float32{1, 1, 0}}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "none"}, Contents: "none"}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	results, err := d.NearestNeighbors(ctx, []float32{0.9, 0.1, 0}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"x", "xy"}, searchNames(results))
+	require.InDelta(t, 0.994, results[0].Score, 0.001)
+
+	_, err = d.NearestNeighbors(ctx, []float32{1, 0}, 2)
+	require.ErrorContains(t, err, "dimensions")
+
+	// Embeddings follow renamed elements and are dropped with removed ones.
+	require.NoError(t, d.RenameElement("y", "why"))
+	require.NoError(t, d.RemoveElement("x"))
+	require.ErrorContains(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "bad"}, Contents: "bad", Embedding: []float32{1, 0}}), "dimensions")
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	results, err = d.NearestNeighbors(ctx, []float32{0, 1, 0}, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"why", "xy"}, searchNames(results))
+}
+
+func TestEmbeddingsWithSameContents(t *testing.T) {
+	ctx := context.Background()
+	m := NewManagerWithStore(NewMemoryStore())
+
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "a"}, Contents: "same", Embedding: []float32{1, 0}}))
+	require.NoError(t, d.AddElement(Element{ElementMeta: ElementMeta{Name: "b"}, Contents: "same", Embedding: []float32{0, 1}}))
+	require.NoError(t, d.Save(ctx))
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+
+	results, err := d.NearestNeighbors(ctx, []float32{1, 0}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, searchNames(results))
+
+	results, err = d.NearestNeighbors(ctx, []float32{0, 1}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, searchNames(results))
+}
@@ -0,0 +1,343 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	encryptionKeyEnvVar          = "GPTSCRIPT_DATASETS_ENCRYPTION_KEY"
+	previousEncryptionKeysEnvVar = "GPTSCRIPT_DATASETS_PREVIOUS_ENCRYPTION_KEYS"
+)
+
+// encryptionHeader marks files that were encrypted by an EncryptedStore.
+var encryptionHeader = []byte("\x00GDSenc1")
+
+// KeyProvider supplies the AES-256 master keys that an EncryptedStore wraps data keys with.
+type KeyProvider interface {
+	// CurrentKey returns the key that new files are encrypted with, along with its ID.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID, which can be a previous key.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider with a fixed set of keys. The ID of a key is derived from its hash.
+type StaticKeyProvider struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider that encrypts with the current key, and can still
+// decrypt files that were encrypted with any of the previous keys.
+func NewStaticKeyProvider(current []byte, previous ...[]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{keys: make(map[string][]byte)}
+	for _, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption keys must be 32 bytes, got %d", len(key))
+		}
+
+		sum := sha256.Sum256(key)
+		p.keys[hex.EncodeToString(sum[:8])] = key
+	}
+
+	sum := sha256.Sum256(current)
+	p.currentID = hex.EncodeToString(sum[:8])
+	return p, nil
+}
+
+// KeyProviderFromEnv returns a KeyProvider with the base64 encoded key in
+// GPTSCRIPT_DATASETS_ENCRYPTION_KEY, and the comma separated previous keys in
+// GPTSCRIPT_DATASETS_PREVIOUS_ENCRYPTION_KEYS. It returns nil if no key is set.
+func KeyProviderFromEnv() (KeyProvider, error) {
+	current := os.Getenv(encryptionKeyEnvVar)
+	if current == "" {
+		return nil, nil
+	}
+
+	var keys [][]byte
+	for _, encoded := range append([]string{current}, strings.Split(os.Getenv(previousEncryptionKeysEnvVar), ",")...) {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewStaticKeyProvider(keys[0], keys[1:]...)
+}
+
+func (p *StaticKeyProvider) CurrentKey(context.Context) (string, []byte, error) {
+	return p.currentID, p.keys[p.currentID], nil
+}
+
+func (p *StaticKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s", id)
+	}
+	return key, nil
+}
+
+// EncryptedStore encrypts files with AES-GCM before writing them to the underlying Store. Every
+// file is encrypted with its own random data key, which is stored in the file's header wrapped
+// with the master key from the KeyProvider. Files written without encryption can still be read.
+type EncryptedStore struct {
+	Store
+	keys KeyProvider
+}
+
+// NewEncryptedStore returns a Store that encrypts files with keys from the KeyProvider. If keys is
+// nil, files are written unencrypted and reading an encrypted file returns an error.
+func NewEncryptedStore(s Store, keys KeyProvider) *EncryptedStore {
+	return &EncryptedStore{Store: s, keys: keys}
+}
+
+func (s *EncryptedStore) Unwrap() Store {
+	return s.Store
+}
+
+func (s *EncryptedStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.Store.ReadFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(ctx, name, data)
+}
+
+func (s *EncryptedStore) WriteFile(ctx context.Context, name string, contents []byte) error {
+	data, err := s.encode(ctx, name, contents)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.WriteFile(ctx, name, data)
+}
+
+// encode encrypts the contents of the named file, or returns them as they are if there is no key.
+func (s *EncryptedStore) encode(ctx context.Context, name string, contents []byte) ([]byte, error) {
+	if s.keys == nil {
+		return contents, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	ciphertext, err := seal(dataKey, contents, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", name, err)
+	}
+
+	e, err := s.wrapDataKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", name, err)
+	}
+
+	e.ciphertext = ciphertext
+	return e.marshal(), nil
+}
+
+func (s *EncryptedStore) decode(ctx context.Context, name string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptionHeader) {
+		return data, nil
+	}
+
+	e, err := parseEncryptedFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+	}
+
+	dataKey, err := s.unwrapDataKey(ctx, e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+	}
+
+	plaintext, err := open(dataKey, e.ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", name, err)
+	}
+	return plaintext, nil
+}
+
+// RotateKeys re-encrypts the files with the given prefix that aren't encrypted with the current
+// key. Only the wrapped data keys are rewritten, so the file contents are not re-encrypted.
+// Unencrypted files are encrypted.
+func (s *EncryptedStore) RotateKeys(ctx context.Context, prefix string) error {
+	if s.keys == nil {
+		return errors.New("no encryption key is configured")
+	}
+
+	currentID, _, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+
+	files, err := s.Store.ListFiles(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	for _, file := range files {
+		data, err := s.Store.ReadFile(ctx, file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		if !bytes.HasPrefix(data, encryptionHeader) {
+			if err := s.WriteFile(ctx, file, data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e, err := parseEncryptedFile(data)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", file, err)
+		} else if e.keyID == currentID {
+			continue
+		}
+
+		dataKey, err := s.unwrapDataKey(ctx, e)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", file, err)
+		}
+
+		rewrapped, err := s.wrapDataKey(ctx, dataKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", file, err)
+		}
+
+		rewrapped.ciphertext = e.ciphertext
+		if err := s.Store.WriteFile(ctx, file, rewrapped.marshal()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// blobNameKey returns the key that the names of blobs are derived with. It is derived from the
+// current master key, so it changes when the key is rotated.
+func (s *EncryptedStore) blobNameKey(ctx context.Context) ([]byte, error) {
+	_, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("gptscript datasets blob names"))
+	return mac.Sum(nil), nil
+}
+
+func (s *EncryptedStore) wrapDataKey(ctx context.Context, dataKey []byte) (encryptedFile, error) {
+	keyID, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return encryptedFile{}, fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+
+	wrapped, err := seal(key, dataKey, []byte(keyID))
+	if err != nil {
+		return encryptedFile{}, err
+	}
+
+	return encryptedFile{keyID: keyID, wrappedKey: wrapped}, nil
+}
+
+func (s *EncryptedStore) unwrapDataKey(ctx context.Context, e encryptedFile) ([]byte, error) {
+	if s.keys == nil {
+		return nil, errors.New("file is encrypted, but no encryption key is configured")
+	}
+
+	key, err := s.keys.Key(ctx, e.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(key, e.wrappedKey, []byte(e.keyID))
+}
+
+// encryptedFile is the layout of an encrypted file: the header, the length of the key ID and
+// the key ID, the length of the wrapped data key and the wrapped data key, and the ciphertext.
+type encryptedFile struct {
+	keyID      string
+	wrappedKey []byte
+	ciphertext []byte
+}
+
+func parseEncryptedFile(data []byte) (encryptedFile, error) {
+	data = bytes.TrimPrefix(data, encryptionHeader)
+
+	var fields [2][]byte
+	for i := range fields {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return encryptedFile{}, errors.New("invalid encrypted file header")
+		}
+		fields[i], data = data[1:1+int(data[0])], data[1+int(data[0]):]
+	}
+
+	return encryptedFile{keyID: string(fields[0]), wrappedKey: fields[1], ciphertext: data}, nil
+}
+
+func (e encryptedFile) marshal() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(encryptionHeader)
+	buf.WriteByte(byte(len(e.keyID)))
+	buf.WriteString(e.keyID)
+	buf.WriteByte(byte(len(e.wrappedKey)))
+	buf.Write(e.wrappedKey)
+	buf.Write(e.ciphertext)
+	return buf.Bytes()
+}
+
+// seal encrypts the plaintext with AES-GCM and returns the nonce followed by the ciphertext.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,207 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staleLockAge is how old a lock file must be before it is considered abandoned.
+const staleLockAge = time.Minute
+
+// LocalStore stores dataset files in a directory on the local filesystem.
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) ReadFile(_ context.Context, name string) ([]byte, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *LocalStore) WriteFile(_ context.Context, name string, contents []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see a partially written file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *LocalStore) DeleteFile(_ context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *LocalStore) ListFiles(_ context.Context, prefix string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+
+		if rel = filepath.ToSlash(rel); strings.HasPrefix(rel, prefix) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (s *LocalStore) modTime(_ context.Context, name string) (time.Time, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return time.Time{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func (s *LocalStore) readFileVersion(ctx context.Context, name string) ([]byte, string, error) {
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, contentVersion(data), nil
+}
+
+// writeFileIfVersion holds a lock file next to the file while it checks the version and writes the
+// file, so that other processes can't replace the same version at the same time.
+func (s *LocalStore) writeFileIfVersion(ctx context.Context, name string, contents []byte, version string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if version != "" {
+			return fmt.Errorf("%s: %w", name, ErrConflict)
+		}
+	} else if err != nil {
+		return err
+	} else if version != contentVersion(data) {
+		return fmt.Errorf("%s: %w", name, ErrConflict)
+	}
+
+	return s.WriteFile(ctx, name, contents)
+}
+
+// lockFile creates a lock file for the file at path, waiting for it to be removed if it exists. The
+// lock file starts with a dot, so ListFiles skips it.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	lock := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		// A lock that is held for much longer than a write takes was left behind by a process that died.
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lock)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *LocalStore) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
@@ -3,60 +3,197 @@ package dataset
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-
-	"github.com/gptscript-ai/go-gptscript"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
 )
 
 const (
 	datasetFolder = "datasets"
 )
 
+// datasetFile is the persisted manifest of a dataset. It holds the dataset metadata and the
+// ordered element metadata, while element contents are stored in separate files.
+type datasetFile struct {
+	Version     int `json:"version"`
+	Revision    int `json:"revision,omitempty"`
+	DatasetMeta `json:",inline"`
+	Elements    []elementFile `json:"elements,omitempty"`
+}
+
+type elementFile struct {
+	ElementMeta `json:",inline"`
+	Blob        string `json:"blob,omitempty"`
+	File        string `json:"file,omitempty"`
+	Binary      bool   `json:"binary,omitempty"`
+	// Embedding is stored with the metadata, since it is supplied by the caller rather than
+	// computed from the contents.
+	Embedding []float32 `json:"embedding,omitempty"`
+
+	// Contents and BinaryContents are only set for elements of migrated datasets that have
+	// not been saved in the current format yet.
+	Contents       string `json:"contents,omitempty"`
+	BinaryContents []byte `json:"binaryContents,omitempty"`
+
+	// index is only used while migrating.
+	index int
+}
+
 type Manager struct {
-	gptscriptClient *gptscript.GPTScript
-	workspaceID     string
+	store Store
+	// records is set when the store keeps datasets as records rather than as manifest files.
+	records *records
+	// conditional is set when the store can replace manifests only if they haven't changed.
+	conditional *conditionalFiles
+	// source is recorded as the provenance of the datasets and elements that the Manager creates.
+	source string
 }
 
+// NewManager returns a Manager that stores datasets in the given GPTScript workspace. Dataset
+// files are compressed, and encrypted if a key is set in the environment (see KeyProviderFromEnv).
+//
+// If GPTSCRIPT_DATASETS_SQLITE_DIR is set, datasets are stored in a SQLite database for the
+// workspace in that directory instead, and if GPTSCRIPT_DATASETS_S3_BUCKET is set, they are stored
+// in that S3 bucket (see s3StoreFromEnv).
 func NewManager(workspaceID string) (Manager, error) {
-	g, err := gptscript.NewGPTScript()
+	dir, bucket := os.Getenv(sqliteDirEnvVar), os.Getenv(s3BucketEnvVar)
+	if dir != "" && bucket != "" {
+		return Manager{}, fmt.Errorf("only one of %s and %s can be set", sqliteDirEnvVar, s3BucketEnvVar)
+	}
+
+	if dir != "" {
+		s, err := sqliteStoreForWorkspace(dir, workspaceID)
+		if err != nil {
+			return Manager{}, err
+		}
+		return newManager(s)
+	} else if bucket != "" {
+		s, err := s3StoreFromEnv(workspaceID)
+		if err != nil {
+			return Manager{}, err
+		}
+		return newManager(s)
+	}
+
+	ws, err := NewWorkspaceStore(workspaceID)
 	if err != nil {
-		return Manager{}, fmt.Errorf("failed to create GPTScript: %w", err)
+		return Manager{}, err
 	}
 
-	return Manager{gptscriptClient: g, workspaceID: workspaceID}, nil
+	return newManager(ws)
 }
 
-func (m *Manager) ListDatasets(ctx context.Context) ([]DatasetMeta, error) {
-	files, err := m.gptscriptClient.ListFilesInWorkspace(ctx, gptscript.ListFilesInWorkspaceOptions{
-		Prefix:      datasetFolder,
-		WorkspaceID: m.workspaceID,
-	})
+// NewLocalManager returns a Manager that stores datasets directly in a directory, without going
+// through GPTScript. Like with NewManager, dataset files are compressed and can be encrypted.
+func NewLocalManager(dir string) (Manager, error) {
+	if dir == "" {
+		return Manager{}, errors.New("dataset directory is required")
+	}
+
+	return newManager(NewLocalStore(dir))
+}
+
+// newManager returns a Manager that compresses dataset files, and encrypts them if a key is set in
+// the environment, before storing them in s.
+func newManager(s Store) (Manager, error) {
+	keys, err := KeyProviderFromEnv()
+	if err != nil {
+		return Manager{}, err
+	}
+
+	return NewManagerWithStore(NewCompressedStore(NewEncryptedStore(s, keys))), nil
+}
+
+// workspaceKey identifies the workspace in the names of databases and object keys. Workspace IDs
+// are URLs, so they are hashed rather than used as they are.
+func workspaceKey(workspaceID string) string {
+	sum := sha256.Sum256([]byte(workspaceID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// NewManagerWithStore returns a Manager that stores datasets in the given Store.
+func NewManagerWithStore(store Store) Manager {
+	return Manager{store: store, records: findRecords(store), conditional: findConditionalFiles(store)}
+}
+
+// SetSource sets the tool or program that is recorded as having created the datasets and
+// elements that are added through the Manager.
+func (m *Manager) SetSource(source string) {
+	m.source = source
+}
+
+// ListDatasets returns the datasets that have all the labels in the selector. If the selector is
+// empty, all datasets are returned.
+func (m *Manager) ListDatasets(ctx context.Context, selector map[string]string) ([]DatasetMeta, error) {
+	var (
+		datasets []DatasetMeta
+		seen     = make(map[string]struct{})
+	)
+	if m.records != nil {
+		records, err := m.records.store.listDatasetRecords(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list datasets: %w", err)
+		}
+
+		for _, record := range records {
+			d, err := m.decodeDatasetRecord(ctx, record)
+			if err != nil {
+				return nil, err
+			}
+
+			seen[idToFileName(d.ID)] = struct{}{}
+			if matchesSelector(d.Labels, selector) {
+				datasets = append(datasets, d.DatasetMeta)
+			}
+		}
+	}
+
+	files, err := m.store.ListFiles(ctx, datasetFolder)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list dataset files: %w", err)
 	}
 
-	var datasets []DatasetMeta
 	for _, file := range files {
-		contents, err := m.gptscriptClient.ReadFileInWorkspace(ctx, file, gptscript.ReadFileInWorkspaceOptions{
-			WorkspaceID: m.workspaceID,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to read dataset file %s: %w", file, err)
+		// Older datasets keep element files in per-dataset folders, so only look at the manifests.
+		if !isManifest(file) {
+			continue
+		}
+		// A dataset that was just moved to the records can still have its manifest.
+		if _, ok := seen[strings.TrimPrefix(file, datasetFolder+"/")]; ok {
+			continue
 		}
 
-		var d Dataset
-		if err = json.Unmarshal(contents, &d); err != nil {
-			return nil, fmt.Errorf("failed to read dataset file %s: %w", file, err)
+		d, err := m.readDataset(ctx, file)
+		if err != nil {
+			return nil, err
 		}
 
-		datasets = append(datasets, d.DatasetMeta)
+		if matchesSelector(d.Labels, selector) {
+			datasets = append(datasets, d.DatasetMeta)
+		}
 	}
 
 	return datasets, nil
 }
 
+// SortByRecency sorts datasets by when they were last updated, most recent first. Datasets
+// without timestamps are sorted last.
+func SortByRecency(datasets []DatasetMeta) {
+	sort.SliceStable(datasets, func(i, j int) bool {
+		a, b := datasets[i].UpdatedAt, datasets[j].UpdatedAt
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.After(*b)
+	})
+}
+
 func (m *Manager) NewDataset(ctx context.Context, name, description string) (Dataset, error) {
 	randBytes := make([]byte, 3)
 	if _, err := rand.Read(randBytes); err != nil {
@@ -64,57 +201,338 @@ func (m *Manager) NewDataset(ctx context.Context, name, description string) (Dat
 	}
 
 	id := fmt.Sprintf("gds://%x", randBytes)[:11]
+	createdAt := now()
 	d := Dataset{
+		m: m,
 		DatasetMeta: DatasetMeta{
 			ID:          id,
 			Name:        name,
 			Description: description,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+			CreatedBy:   m.source,
 		},
 		Elements: make(map[string]Element),
+		revision: 1,
 	}
 
-	// Now convert to JSON and save it to the workspace
-	datasetJSON, err := json.Marshal(d)
-	if err != nil {
-		return Dataset{}, fmt.Errorf("failed to marshal dataset: %w", err)
+	if err := d.write(ctx, 0, ""); err != nil {
+		return Dataset{}, err
 	}
 
-	if err := m.gptscriptClient.WriteFileInWorkspace(ctx, datasetFolder+"/"+idToFileName(id), datasetJSON, gptscript.WriteFileInWorkspaceOptions{
-		WorkspaceID: m.workspaceID,
-	}); err != nil {
-		return Dataset{}, fmt.Errorf("failed to write dataset file: %w", err)
-	}
-
-	d.m = m
 	return d, nil
 }
 
 func (m *Manager) GetDataset(ctx context.Context, id string) (Dataset, error) {
-	fileName := idToFileName(id)
-	data, err := m.gptscriptClient.ReadFileInWorkspace(ctx, datasetFolder+"/"+fileName, gptscript.ReadFileInWorkspaceOptions{
-		WorkspaceID: m.workspaceID,
-	})
+	if !validID.MatchString(id) {
+		return Dataset{}, fmt.Errorf("dataset %s not found", id)
+	}
+
+	d, err := m.getDataset(ctx, id)
 	if err != nil {
-		if !isNotFoundInWorkspaceError(err) {
+		if errors.Is(err, ErrNotFound) {
 			return Dataset{}, fmt.Errorf("dataset %s not found", id)
 		}
-		return Dataset{}, fmt.Errorf("failed to read dataset file: %w", err)
+		return Dataset{}, err
+	}
+
+	return d, nil
+}
+
+// getDataset reads the dataset from the records, if the store has them, or from its manifest.
+func (m *Manager) getDataset(ctx context.Context, id string) (Dataset, error) {
+	if m.records != nil {
+		d, err := m.readRecords(ctx, id)
+		// Datasets that haven't been saved since the store got records still have a manifest.
+		if !errors.Is(err, ErrNotFound) {
+			return d, err
+		}
+	}
+
+	return m.readDataset(ctx, datasetFolder+"/"+idToFileName(id))
+}
+
+// GetElement returns an element of the dataset, with its contents. Unlike reading the whole dataset
+// with GetDataset, it only reads the one element if the store keeps datasets as records.
+func (m *Manager) GetElement(ctx context.Context, id, name string) (Element, error) {
+	if !validID.MatchString(id) {
+		return Element{}, fmt.Errorf("dataset %s not found", id)
+	}
+
+	// An element that isn't found under its key can still have been stored under the key derived
+	// from a previous encryption key, so then the whole dataset is read instead.
+	if m.records != nil {
+		key, err := m.elementKey(ctx, id, name)
+		if err != nil {
+			return Element{}, fmt.Errorf("failed to derive key of element %s: %w", name, err)
+		}
+
+		record, found, err := m.records.store.readElementRecord(ctx, id, key)
+		if err == nil && found {
+			element, err := m.decodeElementRecord(ctx, id, record)
+			if err != nil {
+				return Element{}, err
+			}
+			return m.loadContents(ctx, element)
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return Element{}, fmt.Errorf("failed to read element %s: %w", name, err)
+		}
+	}
+
+	d, err := m.GetDataset(ctx, id)
+	if err != nil {
+		return Element{}, err
+	}
+	return d.GetElement(ctx, name)
+}
+
+// ListElementsPage returns the metadata of up to limit elements of the dataset, starting at
+// position offset, along with the number of elements in the dataset. A limit of 0 means no limit.
+// Like GetElement, it only reads the requested elements if the store keeps datasets as records.
+func (m *Manager) ListElementsPage(ctx context.Context, id string, offset, limit int) ([]ElementMeta, int, error) {
+	if !validID.MatchString(id) {
+		return nil, 0, fmt.Errorf("dataset %s not found", id)
+	}
+
+	if m.records != nil {
+		total, records, err := m.records.store.readElementRecords(ctx, id, max(offset, 0), limit)
+		if err == nil {
+			var elementMetas []ElementMeta
+			for _, record := range records {
+				element, err := m.decodeElementRecord(ctx, id, record)
+				if err != nil {
+					return nil, 0, err
+				}
+				elementMetas = append(elementMetas, element.ElementMeta)
+			}
+			return elementMetas, total, nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, 0, fmt.Errorf("failed to read elements: %w", err)
+		}
+	}
+
+	d, err := m.GetDataset(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d.ListElementsPage(offset, limit), d.GetLength(), nil
+}
+
+// DeleteDataset deletes the dataset and its search index. The contents of its elements can be shared
+// with other datasets, so they are left for CollectGarbage to delete.
+func (m *Manager) DeleteDataset(ctx context.Context, id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("dataset %s not found", id)
+	}
+
+	deleted := false
+	if m.records != nil {
+		if err := m.records.store.deleteRecords(ctx, id); err == nil {
+			deleted = true
+		} else if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete dataset: %w", err)
+		}
 	}
 
-	var d Dataset
-	if err = json.Unmarshal(data, &d); err != nil {
-		return Dataset{}, fmt.Errorf("failed to unmarshal dataset file %s: %w", datasetFolder+"/"+fileName, err)
+	if err := m.store.DeleteFile(ctx, datasetFolder+"/"+idToFileName(id)); err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete dataset file: %w", err)
+		} else if !deleted {
+			return fmt.Errorf("dataset %s not found", id)
+		}
+	}
+
+	segments, err := m.store.ListFiles(ctx, searchIndexFolder(id))
+	if err != nil {
+		return fmt.Errorf("failed to list search index of dataset %s: %w", id, err)
+	}
+
+	for _, file := range append(segments, searchIndexFile(id), vectorsFile(id)) {
+		if err := m.store.DeleteFile(ctx, file); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete %s of dataset %s: %w", file, id, err)
+		}
+	}
+	return nil
+}
+
+// RotateEncryptionKeys re-encrypts every dataset file that isn't encrypted with the current key,
+// and every dataset that is stored as records. The names of blobs are derived from the key, so
+// every blob is stored again under its new name, and the old blobs are deleted without a grace
+// period, so it must not run at the same time as a Save.
+func (m *Manager) RotateEncryptionKeys(ctx context.Context) error {
+	e, _, ok := findStore[*EncryptedStore](m.store)
+	if !ok {
+		return errors.New("the dataset store is not encrypted")
+	} else if e.keys == nil {
+		return errors.New("no encryption key is configured")
+	}
+
+	if err := m.renameBlobs(ctx); err != nil {
+		return err
+	}
+	if err := m.CollectGarbage(ctx, 0); err != nil {
+		return err
+	}
+
+	for _, prefix := range []string{datasetFolder + "/", blobFolder + "/"} {
+		if err := e.RotateKeys(ctx, prefix); err != nil {
+			return err
+		}
+	}
+	return m.rewriteRecords(ctx)
+}
+
+// readDataset reads a dataset manifest from the store. The element contents are not read.
+func (m *Manager) readDataset(ctx context.Context, file string) (Dataset, error) {
+	data, err := m.store.ReadFile(ctx, file)
+	if err != nil {
+		return Dataset{}, fmt.Errorf("failed to read dataset file %s: %w", file, err)
+	}
+
+	var version int
+	if data, version, err = migrateDatasetFile(data); err != nil {
+		return Dataset{}, fmt.Errorf("failed to migrate dataset file %s: %w", file, err)
+	}
+
+	var manifest datasetFile
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return Dataset{}, fmt.Errorf("failed to unmarshal dataset file %s: %w", file, err)
+	}
+
+	d := Dataset{
+		m:           m,
+		DatasetMeta: manifest.DatasetMeta,
+		Elements:    make(map[string]Element, len(manifest.Elements)),
+		revision:    manifest.Revision,
+	}
+	for i, element := range manifest.Elements {
+		d.Elements[element.Name] = Element{
+			ElementMeta:    element.ElementMeta,
+			Index:          i,
+			Contents:       element.Contents,
+			BinaryContents: element.BinaryContents,
+			Embedding:      element.Embedding,
+			blob:           element.Blob,
+			file:           element.File,
+			binary:         element.Binary,
+			loaded:         element.Blob == "" && element.File == "",
+			storedIndex:    i,
+		}
+	}
+
+	if version < 4 {
+		if err := d.readLegacyVectors(ctx); err != nil {
+			return Dataset{}, err
+		}
 	}
 
-	d.m = m
 	return d, nil
 }
 
+// writeDataset writes the manifest of the dataset to the store. The contents of every element
+// must already be stored. If the store can write conditionally, the manifest is only written if it
+// is still at the version that readRevision returned, or doesn't exist if the version is empty.
+func (m *Manager) writeDataset(ctx context.Context, d *Dataset, version string) error {
+	manifest := datasetFile{
+		Version:     currentDatasetVersion,
+		Revision:    d.revision,
+		DatasetMeta: d.DatasetMeta,
+	}
+	for _, element := range d.sortedElements() {
+		manifest.Elements = append(manifest.Elements, elementFile{
+			ElementMeta: element.ElementMeta,
+			Blob:        element.blob,
+			File:        element.file,
+			Binary:      element.binary,
+			Embedding:   element.Embedding,
+		})
+	}
+
+	datasetJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dataset: %w", err)
+	}
+
+	file := datasetFolder + "/" + idToFileName(d.ID)
+	if m.conditional != nil {
+		err = m.conditional.writeFile(ctx, file, datasetJSON, version)
+	} else {
+		err = m.store.WriteFile(ctx, file, datasetJSON)
+	}
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			return fmt.Errorf("failed to save dataset %s at revision %d: %w", d.ID, d.revision-1, ErrConflict)
+		}
+		return fmt.Errorf("failed to write dataset file: %w", err)
+	}
+	return nil
+}
+
+// loadContents reads the contents of the element from the store if they haven't been read yet.
+func (m *Manager) loadContents(ctx context.Context, e Element) (Element, error) {
+	if e.loaded {
+		return e, nil
+	}
+
+	file := e.file
+	if e.blob != "" {
+		file = blobFile(e.blob)
+	}
+
+	data, err := m.store.ReadFile(ctx, file)
+	if err != nil {
+		return Element{}, fmt.Errorf("failed to read contents of element %s: %w", e.Name, err)
+	}
+
+	if e.Contents, e.BinaryContents = "", nil; len(data) > 0 {
+		if e.binary {
+			e.BinaryContents = data
+		} else {
+			e.Contents = string(data)
+		}
+	}
+
+	e.loaded = true
+	return e, nil
+}
+
+// readRevision returns the revision of the dataset as it is currently stored, along with the
+// version of its manifest if the store can write conditionally.
+func (m *Manager) readRevision(ctx context.Context, id string) (int, string, error) {
+	var (
+		file    = datasetFolder + "/" + idToFileName(id)
+		data    []byte
+		version string
+		err     error
+	)
+	if m.conditional != nil {
+		data, version, err = m.conditional.readFile(ctx, file)
+	} else {
+		data, err = m.store.ReadFile(ctx, file)
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read dataset file: %w", err)
+	}
+
+	var manifest struct {
+		Revision int `json:"revision"`
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return 0, "", fmt.Errorf("failed to unmarshal dataset file: %w", err)
+	}
+
+	return manifest.Revision, version, nil
+}
+
+// validID matches the IDs that NewDataset generates. IDs from requests must match it before they
+// are turned into file names.
+var validID = regexp.MustCompile(`^gds://[a-z0-9]{5}$`)
+
 func idToFileName(id string) string {
-	return id[6:] + ".gds"
+	return strings.TrimPrefix(id, "gds://") + ".gds"
 }
 
-func isNotFoundInWorkspaceError(err error) bool {
-	var notFoundErr *gptscript.NotFoundInWorkspaceError
-	return errors.As(err, &notFoundErr)
+func isManifest(file string) bool {
+	name, ok := strings.CutPrefix(file, datasetFolder+"/")
+	return ok && !strings.Contains(name, "/") && strings.HasSuffix(name, ".gds")
 }
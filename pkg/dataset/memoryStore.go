@@ -0,0 +1,110 @@
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps dataset files in memory. It is useful for tests and for short-lived
+// runs that don't need their datasets to be persisted.
+type MemoryStore struct {
+	lock     sync.RWMutex
+	files    map[string][]byte
+	modified map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{files: make(map[string][]byte), modified: make(map[string]time.Time)}
+}
+
+func (s *MemoryStore) ReadFile(_ context.Context, name string) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, exists := s.files[name]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	return slices.Clone(data), nil
+}
+
+func (s *MemoryStore) WriteFile(_ context.Context, name string, contents []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.files[name] = slices.Clone(contents)
+	s.modified[name] = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) DeleteFile(_ context.Context, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, exists := s.files[name]; !exists {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	delete(s.files, name)
+	delete(s.modified, name)
+	return nil
+}
+
+func (s *MemoryStore) ListFiles(_ context.Context, prefix string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var files []string
+	for name := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (s *MemoryStore) modTime(_ context.Context, name string) (time.Time, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	modified, exists := s.modified[name]
+	if !exists {
+		return time.Time{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	return modified, nil
+}
+
+func (s *MemoryStore) readFileVersion(_ context.Context, name string) ([]byte, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, exists := s.files[name]
+	if !exists {
+		return nil, "", fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	return slices.Clone(data), contentVersion(data), nil
+}
+
+func (s *MemoryStore) writeFileIfVersion(_ context.Context, name string, contents []byte, version string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, exists := s.files[name]
+	if (!exists && version != "") || (exists && version != contentVersion(data)) {
+		return fmt.Errorf("%s: %w", name, ErrConflict)
+	}
+
+	s.files[name] = slices.Clone(contents)
+	s.modified[name] = time.Now()
+	return nil
+}
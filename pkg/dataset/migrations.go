@@ -0,0 +1,111 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// currentDatasetVersion is the version of the dataset file format that is written by Save.
+//
+//  1. A single file with the dataset metadata and a map of elements, contents included.
+//     Files without a version are version 1.
+//  2. A manifest with the dataset metadata and an ordered list of elements, with the contents
+//     of each element in its own file in the dataset's folder.
+//  3. Element contents are stored in blobs shared by all datasets, referenced by their hash.
+//  4. Element embeddings are stored in the manifest, instead of in a vectors file keyed by blob.
+const currentDatasetVersion = 4
+
+// migration upgrades the decoded fields of a dataset file by one version.
+type migration func(fields map[string]json.RawMessage) error
+
+// migrations is keyed by the version that the migration upgrades from.
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+	2: migrateV2ToV3,
+	3: migrateV3ToV4,
+}
+
+// migrateDatasetFile upgrades the given dataset file to currentDatasetVersion. It also returns the
+// version that the file had.
+func migrateDatasetFile(data []byte) ([]byte, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	version := 1
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid version: %w", err)
+		}
+	}
+
+	if version > currentDatasetVersion {
+		return nil, 0, fmt.Errorf("dataset file version %d is newer than the supported version %d", version, currentDatasetVersion)
+	} else if version == currentDatasetVersion {
+		return data, version, nil
+	}
+
+	for v := version; v < currentDatasetVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return nil, 0, fmt.Errorf("no migration from dataset file version %d", v)
+		}
+		if err := migrate(fields); err != nil {
+			return nil, 0, fmt.Errorf("failed to migrate dataset file from version %d: %w", v, err)
+		}
+	}
+
+	fields["version"] = json.RawMessage(fmt.Sprint(currentDatasetVersion))
+	data, err := json.Marshal(fields)
+	return data, version, err
+}
+
+// migrateV1ToV2 turns the map of elements into a list ordered by index. The contents stay
+// inline until the dataset is saved, which moves them into their own files.
+func migrateV1ToV2(fields map[string]json.RawMessage) error {
+	raw, ok := fields["elements"]
+	if !ok || len(raw) == 0 || raw[0] != '{' {
+		// Manifests written before the version field was added already use a list.
+		return nil
+	}
+
+	var elements map[string]Element
+	if err := json.Unmarshal(raw, &elements); err != nil {
+		return err
+	}
+
+	list := make([]elementFile, 0, len(elements))
+	for _, element := range elements {
+		list = append(list, elementFile{
+			ElementMeta:    element.ElementMeta,
+			index:          element.Index,
+			Contents:       element.Contents,
+			BinaryContents: element.BinaryContents,
+		})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].index < list[j].index
+	})
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	fields["elements"] = data
+	return nil
+}
+
+// migrateV2ToV3 doesn't change anything: the element files of version 2 stay readable, and
+// their contents are moved to blobs when the dataset is saved.
+func migrateV2ToV3(map[string]json.RawMessage) error {
+	return nil
+}
+
+// migrateV3ToV4 doesn't change anything either: the embeddings in the vectors file of a version 3
+// dataset are read into its elements, and the vectors file is deleted when the dataset is saved.
+func migrateV3ToV4(map[string]json.RawMessage) error {
+	return nil
+}
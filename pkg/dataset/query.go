@@ -0,0 +1,86 @@
+package dataset
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ElementQuery selects the elements of a dataset. An empty query matches every element.
+type ElementQuery struct {
+	// Glob is matched against the name and description of each element, ignoring case. A * matches
+	// any number of characters and a ? matches any single character.
+	Glob string
+	// Regex is a regular expression that is matched against the name and description of each element.
+	Regex string
+	// Metadata selects the elements that have all of the given metadata keys and values.
+	Metadata map[string]string
+}
+
+// FindElements returns the metadata of the elements that match the query, in order.
+func (d *Dataset) FindElements(q ElementQuery) ([]ElementMeta, error) {
+	matches, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var elementMetas []ElementMeta
+	for _, element := range d.sortedElements() {
+		if matches(element) {
+			elementMetas = append(elementMetas, element.ElementMeta)
+		}
+	}
+	return elementMetas, nil
+}
+
+func (q ElementQuery) matcher() (func(Element) bool, error) {
+	var patterns []*regexp.Regexp
+	if q.Glob != "" {
+		patterns = append(patterns, globToRegexp(q.Glob))
+	}
+	if q.Regex != "" {
+		re, err := regexp.Compile(q.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", q.Regex, err)
+		}
+		patterns = append(patterns, re)
+	}
+
+	return func(e Element) bool {
+		for _, re := range patterns {
+			if !re.MatchString(e.Name) && !re.MatchString(e.Description) {
+				return false
+			}
+		}
+		return matchesSelector(e.Metadata, q.Metadata)
+	}, nil
+}
+
+// matchesSelector returns true if values has every key and value in the selector.
+func matchesSelector(values, selector map[string]string) bool {
+	for key, value := range selector {
+		if v, ok := values[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// globToRegexp converts a glob into an equivalent case-insensitive regular expression. Unlike
+// path.Match, a * also matches slashes, since element names are not paths.
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?is)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
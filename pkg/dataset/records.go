@@ -0,0 +1,322 @@
+package dataset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// datasetRecord is the stored form of a dataset in a recordStore: the dataset metadata, without
+// the elements, as encoded by the codecs.
+type datasetRecord struct {
+	ID       string
+	Revision int
+	Data     []byte
+}
+
+// elementRecord is the stored form of an element in a recordStore, under the key of the element
+// (see Manager.elementKey). Data is nil when only the position of the element changed.
+type elementRecord struct {
+	Key      string
+	Position int
+	Data     []byte
+}
+
+// recordStore is implemented by stores that keep datasets and their elements as separate records,
+// rather than as a manifest file per dataset. Every operation is atomic.
+type recordStore interface {
+	// readDatasetRecords returns the dataset and all of its elements, ordered by position.
+	readDatasetRecords(ctx context.Context, id string) (datasetRecord, []elementRecord, error)
+	listDatasetRecords(ctx context.Context) ([]datasetRecord, error)
+	// readElementRecord returns ErrNotFound if the dataset doesn't exist, and false if the element doesn't.
+	readElementRecord(ctx context.Context, id, key string) (elementRecord, bool, error)
+	// readElementRecords returns the number of elements in the dataset, and up to limit elements
+	// starting at position offset. A limit of 0 means no limit.
+	readElementRecords(ctx context.Context, id string, offset, limit int) (int, []elementRecord, error)
+	// writeRecords writes the dataset, the elements that changed, and deletes the elements with
+	// the removed keys. It returns ErrConflict if the stored revision of the dataset isn't
+	// previousRevision, or if previousRevision is 0 and the dataset already exists.
+	writeRecords(ctx context.Context, d datasetRecord, previousRevision int, changed []elementRecord, removed []string) error
+	deleteRecords(ctx context.Context, id string) error
+}
+
+// codec is implemented by stores that transform files before storing them, like CompressedStore
+// and EncryptedStore, so that records and conditionally written files are stored the same way as
+// other files.
+type codec interface {
+	encode(ctx context.Context, name string, data []byte) ([]byte, error)
+	decode(ctx context.Context, name string, data []byte) ([]byte, error)
+}
+
+// codecs are the codecs of the stores that wrap another store, outermost first.
+type codecs []codec
+
+// findStore returns the first store of type T in the chain of wrapped stores that starts with s,
+// along with the codecs of the stores that wrap it.
+func findStore[T any](s Store) (T, codecs, bool) {
+	var cs codecs
+	for {
+		if c, ok := s.(codec); ok {
+			cs = append(cs, c)
+		}
+		if t, ok := s.(T); ok {
+			return t, cs, true
+		}
+
+		wrapper, ok := s.(interface{ Unwrap() Store })
+		if !ok {
+			var zero T
+			return zero, nil, false
+		}
+		s = wrapper.Unwrap()
+	}
+}
+
+func (cs codecs) encode(ctx context.Context, name string, data []byte) ([]byte, error) {
+	var err error
+	for _, c := range cs {
+		if data, err = c.encode(ctx, name, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (cs codecs) decode(ctx context.Context, name string, data []byte) ([]byte, error) {
+	var err error
+	for i := len(cs) - 1; i >= 0; i-- {
+		if data, err = cs[i].decode(ctx, name, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// records is the recordStore that a Manager's store wraps, along with the codecs of the stores
+// that wrap it.
+type records struct {
+	store recordStore
+	codecs
+}
+
+// findRecords returns the records of the store, or nil if the store doesn't keep records.
+func findRecords(s Store) *records {
+	store, cs, ok := findStore[recordStore](s)
+	if !ok {
+		return nil
+	}
+	return &records{store: store, codecs: cs}
+}
+
+// datasetRecordName and elementRecordName are the names that records are encoded under, which
+// encrypted records are bound to.
+func datasetRecordName(id string) string {
+	return "records/" + id
+}
+
+func elementRecordName(id, key string) string {
+	return "records/" + id + "/" + key
+}
+
+// elementKey returns the key that the record of an element is stored under. It is the name of the
+// element, unless the store is encrypted: element names are often file names, URLs or e-mail
+// addresses, so it is an HMAC of the name instead, with the same key as the names of blobs (see
+// blobName). The name itself is only stored in the encrypted record.
+func (m *Manager) elementKey(ctx context.Context, id, name string) (string, error) {
+	e, _, ok := findStore[*EncryptedStore](m.store)
+	if !ok || e.keys == nil {
+		return name, nil
+	}
+
+	key, err := e.blobNameKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(elementRecordName(id, name)))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// readRecords reads a dataset and the metadata of its elements from the records. The element
+// contents are not read.
+func (m *Manager) readRecords(ctx context.Context, id string) (Dataset, error) {
+	record, elements, err := m.records.store.readDatasetRecords(ctx, id)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	d, err := m.decodeDatasetRecord(ctx, record)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	for _, record := range elements {
+		element, err := m.decodeElementRecord(ctx, id, record)
+		if err != nil {
+			return Dataset{}, err
+		}
+		d.Elements[element.Name] = element
+	}
+
+	return d, nil
+}
+
+func (m *Manager) decodeDatasetRecord(ctx context.Context, record datasetRecord) (Dataset, error) {
+	data, err := m.records.decode(ctx, datasetRecordName(record.ID), record.Data)
+	if err != nil {
+		return Dataset{}, fmt.Errorf("failed to read dataset %s: %w", record.ID, err)
+	}
+
+	if data, _, err = migrateDatasetFile(data); err != nil {
+		return Dataset{}, fmt.Errorf("failed to migrate dataset %s: %w", record.ID, err)
+	}
+
+	var manifest datasetFile
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return Dataset{}, fmt.Errorf("failed to unmarshal dataset %s: %w", record.ID, err)
+	}
+
+	return Dataset{
+		m:           m,
+		DatasetMeta: manifest.DatasetMeta,
+		Elements:    make(map[string]Element),
+		revision:    record.Revision,
+		fromRecords: true,
+	}, nil
+}
+
+func (m *Manager) decodeElementRecord(ctx context.Context, id string, record elementRecord) (Element, error) {
+	data, err := m.records.decode(ctx, elementRecordName(id, record.Key), record.Data)
+	if err != nil {
+		return Element{}, fmt.Errorf("failed to read element at position %d: %w", record.Position, err)
+	}
+
+	var element elementFile
+	if err = json.Unmarshal(data, &element); err != nil {
+		return Element{}, fmt.Errorf("failed to unmarshal element at position %d: %w", record.Position, err)
+	}
+
+	return Element{
+		ElementMeta: element.ElementMeta,
+		Index:       record.Position,
+		Embedding:   element.Embedding,
+		blob:        element.Blob,
+		binary:      element.Binary,
+		storedIndex: record.Position,
+		storedKey:   record.Key,
+	}, nil
+}
+
+// writeRecords writes the dataset to the records, replacing the dataset at previousRevision. Only
+// the elements that changed since the dataset was read are written, unless the dataset isn't
+// stored as records yet. The contents of every element must already be stored.
+func (d *Dataset) writeRecords(ctx context.Context, previousRevision int) error {
+	r := d.m.records
+	all := !d.fromRecords
+	if all {
+		previousRevision = 0
+	}
+
+	data, err := json.Marshal(datasetFile{
+		Version:     currentDatasetVersion,
+		DatasetMeta: d.DatasetMeta,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal dataset: %w", err)
+	}
+
+	record := datasetRecord{ID: d.ID, Revision: d.revision}
+	if record.Data, err = r.encode(ctx, datasetRecordName(d.ID), data); err != nil {
+		return fmt.Errorf("failed to encode dataset: %w", err)
+	}
+
+	var changed []elementRecord
+	removed := slices.Clone(d.removed)
+	keys := make(map[string]string, len(d.Elements))
+	for _, element := range d.sortedElements() {
+		key, err := d.m.elementKey(ctx, d.ID, element.Name)
+		if err != nil {
+			return fmt.Errorf("failed to derive key of element %s: %w", element.Name, err)
+		}
+		keys[element.Name] = key
+
+		// The key of an element changes when it is renamed, or when the encryption key changes, and
+		// then the record under its old key is replaced.
+		rekeyed := element.storedKey != "" && element.storedKey != key
+		if rekeyed {
+			removed = append(removed, element.storedKey)
+		}
+		if !all && !rekeyed && !element.changed && element.Index == element.storedIndex {
+			continue
+		}
+
+		e := elementRecord{Key: key, Position: element.Index}
+		if all || rekeyed || element.changed {
+			data, err := json.Marshal(elementFile{
+				ElementMeta: element.ElementMeta,
+				Blob:        element.blob,
+				Binary:      element.binary,
+				Embedding:   element.Embedding,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to marshal element %s: %w", element.Name, err)
+			}
+			if e.Data, err = r.encode(ctx, elementRecordName(d.ID, key), data); err != nil {
+				return fmt.Errorf("failed to encode element %s: %w", element.Name, err)
+			}
+		}
+		changed = append(changed, e)
+	}
+
+	if err := r.store.writeRecords(ctx, record, previousRevision, changed, removed); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return fmt.Errorf("failed to save dataset %s at revision %d: %w", d.ID, previousRevision, err)
+		}
+		return fmt.Errorf("failed to write dataset %s: %w", d.ID, err)
+	}
+
+	for name, element := range d.Elements {
+		element.storedKey = keys[name]
+		d.Elements[name] = element
+	}
+	d.fromRecords = true
+	return nil
+}
+
+// rewriteRecords writes every dataset in the records again, so that they are encoded with the
+// current codecs, such as a new encryption key.
+func (m *Manager) rewriteRecords(ctx context.Context) error {
+	if m.records == nil {
+		return nil
+	}
+
+	datasets, err := m.records.store.listDatasetRecords(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list datasets: %w", err)
+	}
+
+	for _, record := range datasets {
+		d, err := m.readRecords(ctx, record.ID)
+		if err != nil {
+			return err
+		}
+
+		for name, element := range d.Elements {
+			element.changed = true
+			d.Elements[name] = element
+		}
+
+		d.revision++
+		if err := d.writeRecords(ctx, d.revision-1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
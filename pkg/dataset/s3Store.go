@@ -0,0 +1,219 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	s3BucketEnvVar   = "GPTSCRIPT_DATASETS_S3_BUCKET"
+	s3EndpointEnvVar = "GPTSCRIPT_DATASETS_S3_ENDPOINT"
+	s3PrefixEnvVar   = "GPTSCRIPT_DATASETS_S3_PREFIX"
+	s3RegionEnvVar   = "GPTSCRIPT_DATASETS_S3_REGION"
+	s3InsecureEnvVar = "GPTSCRIPT_DATASETS_S3_INSECURE"
+)
+
+var (
+	s3StoresLock sync.Mutex
+	// s3Stores holds the stores that have been created by s3StoreFromEnv, so that every request
+	// for a workspace shares one client and its connections.
+	s3Stores = make(map[S3StoreOptions]*S3Store)
+)
+
+type S3StoreOptions struct {
+	// Endpoint is the host (and optional port) of the S3-compatible service, e.g. "s3.amazonaws.com".
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to every object key, which allows several stores to share a bucket.
+	Prefix string
+	Region string
+	// AccessKeyID and SecretAccessKey are used if set; otherwise credentials are read from the
+	// standard AWS environment variables.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Insecure uses plain HTTP to talk to the endpoint.
+	Insecure bool
+}
+
+// S3Store stores dataset files as objects in an S3-compatible bucket.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// s3StoreFromEnv returns a store for the workspace in the bucket in GPTSCRIPT_DATASETS_S3_BUCKET,
+// or nil if no bucket is set. The endpoint defaults to AWS, and can be set, along with the region,
+// in GPTSCRIPT_DATASETS_S3_ENDPOINT and GPTSCRIPT_DATASETS_S3_REGION. GPTSCRIPT_DATASETS_S3_INSECURE
+// uses plain HTTP. Credentials are read from the standard AWS environment variables.
+//
+// The files of the workspace are stored under the prefix in GPTSCRIPT_DATASETS_S3_PREFIX, followed
+// by a hash of the workspace ID.
+func s3StoreFromEnv(workspaceID string) (*S3Store, error) {
+	bucket := os.Getenv(s3BucketEnvVar)
+	if bucket == "" {
+		return nil, nil
+	}
+
+	opts := S3StoreOptions{
+		Endpoint: os.Getenv(s3EndpointEnvVar),
+		Bucket:   bucket,
+		Prefix:   os.Getenv(s3PrefixEnvVar) + workspaceKey(workspaceID) + "/",
+		Region:   os.Getenv(s3RegionEnvVar),
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "s3.amazonaws.com"
+	}
+	if insecure := os.Getenv(s3InsecureEnvVar); insecure != "" {
+		var err error
+		if opts.Insecure, err = strconv.ParseBool(insecure); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", s3InsecureEnvVar, err)
+		}
+	}
+
+	s3StoresLock.Lock()
+	defer s3StoresLock.Unlock()
+
+	if s, ok := s3Stores[opts]; ok {
+		return s, nil
+	}
+
+	s, err := NewS3Store(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Stores[opts] = s
+	return s, nil
+}
+
+func NewS3Store(opts S3StoreOptions) (*S3Store, error) {
+	creds := credentials.NewEnvAWS()
+	if opts.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, "")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Store{client: client, bucket: opts.Bucket, prefix: opts.Prefix}, nil
+}
+
+func (s *S3Store) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.convertError(name, err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, s.convertError(name, err)
+	}
+
+	return data, nil
+}
+
+func (s *S3Store) WriteFile(ctx context.Context, name string, contents []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+name, bytes.NewReader(contents), int64(len(contents)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *S3Store) DeleteFile(ctx context.Context, name string) error {
+	// S3 silently succeeds when deleting a missing object, so check that it exists first.
+	if _, err := s.client.StatObject(ctx, s.bucket, s.prefix+name, minio.StatObjectOptions{}); err != nil {
+		return s.convertError(name, err)
+	}
+
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	var files []string
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.prefix + prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		files = append(files, strings.TrimPrefix(obj.Key, s.prefix))
+	}
+
+	return files, nil
+}
+
+func (s *S3Store) modTime(ctx context.Context, name string) (time.Time, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, s.prefix+name, minio.StatObjectOptions{})
+	if err != nil {
+		return time.Time{}, s.convertError(name, err)
+	}
+
+	return info.LastModified, nil
+}
+
+// readFileVersion returns the file along with its ETag.
+func (s *S3Store) readFileVersion(ctx context.Context, name string) ([]byte, string, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", s.convertError(name, err)
+	}
+	defer obj.Close()
+
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, "", s.convertError(name, err)
+	}
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, "", s.convertError(name, err)
+	}
+
+	return data, info.ETag, nil
+}
+
+// writeFileIfVersion writes the file with an If-Match or If-None-Match condition on its ETag.
+func (s *S3Store) writeFileIfVersion(ctx context.Context, name string, contents []byte, version string) error {
+	opts := minio.PutObjectOptions{ContentType: "application/octet-stream"}
+	if version == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(version)
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+name, bytes.NewReader(contents), int64(len(contents)), opts)
+	if err != nil {
+		return s.convertError(name, err)
+	}
+	return nil
+}
+
+func (s *S3Store) convertError(name string, err error) error {
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "NoSuchKey" || resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	} else if resp.Code == "PreconditionFailed" || resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: %w", name, ErrConflict)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// SetSchema sets the JSON Schema that the contents of elements must match. The elements that the
+// dataset already has must match it too, so their contents are read, and if any of them don't
+// match, the schema is not changed. A nil schema removes the schema.
+func (d *Dataset) SetSchema(ctx context.Context, schema json.RawMessage) error {
+	if len(schema) == 0 {
+		d.Schema, d.schema = nil, nil
+		return nil
+	}
+
+	compiled, err := compileSchema(d.ID, schema)
+	if err != nil {
+		return err
+	}
+
+	previous, previousCompiled := d.Schema, d.schema
+	d.Schema, d.schema = schema, compiled
+
+	// Report every element that doesn't match, so that they can all be fixed at once.
+	var errs []error
+	for _, element := range d.sortedElements() {
+		element, err := d.loadElement(ctx, element)
+		if err != nil {
+			d.Schema, d.schema = previous, previousCompiled
+			return err
+		}
+		if err := d.validate(element); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		d.Schema, d.schema = previous, previousCompiled
+		return err
+	}
+	return nil
+}
+
+// validate checks the contents of the element against the schema of the dataset, if it has one.
+func (d *Dataset) validate(e Element) error {
+	if len(d.Schema) == 0 {
+		return nil
+	}
+
+	if d.schema == nil {
+		compiled, err := compileSchema(d.ID, d.Schema)
+		if err != nil {
+			return err
+		}
+		d.schema = compiled
+	}
+
+	if len(e.BinaryContents) > 0 {
+		return fmt.Errorf("element %s must have JSON contents, since dataset %s has a schema", e.Name, d.ID)
+	}
+
+	contents, err := jsonschema.UnmarshalJSON(strings.NewReader(e.Contents))
+	if err != nil {
+		return fmt.Errorf("contents of element %s are not valid JSON: %w", e.Name, err)
+	}
+
+	if err := d.schema.Validate(contents); err != nil {
+		return fmt.Errorf("contents of element %s do not match the schema of dataset %s: %w", e.Name, d.ID, err)
+	}
+	return nil
+}
+
+// compileSchema compiles the schema of the dataset with the given ID. The schema's location, which
+// shows up in validation errors, is the dataset ID.
+func compileSchema(id string, schema json.RawMessage) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	// Schemas come from tool callers, so they must not load other schemas from files or the network.
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := c.AddResource(id, doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	compiled, err := c.Compile(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return compiled, nil
+}
@@ -0,0 +1,324 @@
+package dataset
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchResult is an element that matched a search, with its BM25 score.
+type SearchResult struct {
+	ElementMeta `json:",inline"`
+	Score       float64 `json:"score"`
+}
+
+// maxSearchSegments is how many segments the search index of a dataset can have before the
+// smaller half of them are merged.
+const maxSearchSegments = 16
+
+// searchIndex is an inverted index of the text contents of a dataset's elements. Documents are
+// keyed by the hash of their blob, so renaming an element doesn't change the index, and elements
+// with the same contents share a document.
+//
+// The index of a dataset is stored as segments (see searchIndexFolder), and every Save that adds
+// text writes a new segment with only the documents it adds, so that it doesn't have to rewrite the
+// whole index. Segments are never changed, so saves in different processes can't lose each other's
+// documents.
+type searchIndex struct {
+	// Lengths is the number of terms in each document.
+	Lengths map[string]int `json:"lengths"`
+	// Postings maps each term to the documents that contain it, and how often.
+	Postings map[string]map[string]int `json:"postings"`
+}
+
+// searchSegment is a stored part of the search index of a dataset.
+type searchSegment struct {
+	file  string
+	index searchIndex
+}
+
+// searchIndexFile is where the whole search index of a dataset was stored before it was split into
+// segments. It is read as one more segment.
+func searchIndexFile(id string) string {
+	return datasetFolder + "/" + strings.TrimPrefix(id, "gds://") + ".idx"
+}
+
+// searchIndexFolder holds the segments of the search index of a dataset.
+func searchIndexFolder(id string) string {
+	return datasetFolder + "/" + strings.TrimPrefix(id, "gds://") + ".index/"
+}
+
+func newSearchIndex() searchIndex {
+	return searchIndex{
+		Lengths:  make(map[string]int),
+		Postings: make(map[string]map[string]int),
+	}
+}
+
+// add indexes the contents as the document for the blob.
+func (idx searchIndex) add(blob, contents string) {
+	terms := tokenize(contents)
+	idx.Lengths[blob] = len(terms)
+	for _, term := range terms {
+		if idx.Postings[term] == nil {
+			idx.Postings[term] = make(map[string]int)
+		}
+		idx.Postings[term][blob]++
+	}
+}
+
+// merge adds the documents of the other index for which keep returns true.
+func (idx searchIndex) merge(other searchIndex, keep func(blob string) bool) {
+	for blob, length := range other.Lengths {
+		if keep(blob) {
+			idx.Lengths[blob] = length
+		}
+	}
+	for term, postings := range other.Postings {
+		for blob, count := range postings {
+			if !keep(blob) {
+				continue
+			}
+			if idx.Postings[term] == nil {
+				idx.Postings[term] = make(map[string]int)
+			}
+			idx.Postings[term][blob] = count
+		}
+	}
+}
+
+// Search returns up to limit elements whose contents best match the query, ranked by BM25.
+func (d *Dataset) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	index, err := d.m.readSearchIndex(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is written after the dataset, so elements whose documents weren't written, for
+	// example because the process stopped in between, are indexed here instead.
+	for name, element := range d.Elements {
+		if _, ok := index.Lengths[element.blob]; ok || element.binary || element.blob == "" {
+			continue
+		}
+
+		if element, err = d.loadElement(ctx, element); err != nil {
+			return nil, fmt.Errorf("failed to index element %s: %w", name, err)
+		}
+		index.add(element.blob, element.Contents)
+	}
+
+	documents := make(map[string][]Element)
+	for _, element := range d.sortedElements() {
+		if _, ok := index.Lengths[element.blob]; ok {
+			documents[element.blob] = append(documents[element.blob], element)
+		}
+	}
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	var totalLength int
+	for blob := range documents {
+		totalLength += index.Lengths[blob]
+	}
+	avgLength := float64(totalLength) / float64(len(documents))
+
+	scores := make(map[string]float64)
+	for _, term := range tokenize(query) {
+		postings := make(map[string]int)
+		for blob, count := range index.Postings[term] {
+			if _, ok := documents[blob]; ok {
+				postings[blob] = count
+			}
+		}
+
+		idf := math.Log(1 + (float64(len(documents))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for blob, count := range postings {
+			tf := float64(count)
+			scores[blob] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(index.Lengths[blob])/avgLength))
+		}
+	}
+
+	var results []SearchResult
+	for blob, score := range scores {
+		for _, element := range documents[blob] {
+			results = append(results, SearchResult{ElementMeta: element.ElementMeta, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return d.Elements[results[i].Name].Index < d.Elements[results[j].Name].Index
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// updateSearchIndex writes a segment with the text elements that aren't indexed yet, and merges
+// segments if there are too many. It must only be called once the dataset has been written, so
+// that a save that fails doesn't change the index.
+func (d *Dataset) updateSearchIndex(ctx context.Context) error {
+	segments, err := d.m.readSearchSegments(ctx, d.ID)
+	if err != nil {
+		return err
+	}
+
+	indexed := make(map[string]struct{})
+	for _, segment := range segments {
+		for blob := range segment.index.Lengths {
+			indexed[blob] = struct{}{}
+		}
+	}
+
+	added := newSearchIndex()
+	for name, element := range d.Elements {
+		if element.binary || element.blob == "" {
+			continue
+		}
+		if _, ok := indexed[element.blob]; ok {
+			continue
+		}
+		if _, ok := added.Lengths[element.blob]; ok {
+			continue
+		}
+
+		if element, err = d.loadElement(ctx, element); err != nil {
+			return fmt.Errorf("failed to index element %s: %w", name, err)
+		}
+		added.add(element.blob, element.Contents)
+	}
+
+	if len(added.Lengths) > 0 {
+		segment, err := d.m.writeSearchSegment(ctx, d.ID, added)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(segments) > maxSearchSegments {
+		return d.mergeSearchSegments(ctx, segments)
+	}
+	return nil
+}
+
+// mergeSearchSegments merges the smaller half of the segments into one, leaving out the documents
+// that no element of the dataset uses anymore. The dataset may have been changed since it was
+// read, so a document that is left out by mistake is indexed again by Search.
+func (d *Dataset) mergeSearchSegments(ctx context.Context, segments []searchSegment) error {
+	sort.Slice(segments, func(i, j int) bool {
+		return len(segments[i].index.Lengths) < len(segments[j].index.Lengths)
+	})
+	segments = segments[:len(segments)/2+1]
+
+	used := make(map[string]struct{})
+	for _, element := range d.Elements {
+		used[element.blob] = struct{}{}
+	}
+
+	merged := newSearchIndex()
+	for _, segment := range segments {
+		merged.merge(segment.index, func(blob string) bool {
+			_, ok := used[blob]
+			return ok
+		})
+	}
+
+	// The merged segment is written before the others are deleted, so no document is missing
+	// from the index in between.
+	if len(merged.Lengths) > 0 {
+		if _, err := d.m.writeSearchSegment(ctx, d.ID, merged); err != nil {
+			return err
+		}
+	}
+	for _, segment := range segments {
+		if err := d.m.store.DeleteFile(ctx, segment.file); err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to delete search index segment %s: %w", segment.file, err)
+		}
+	}
+	return nil
+}
+
+// writeSearchSegment stores the index as a new segment. Segments have random names, so that
+// segments written by different processes at the same time don't replace each other.
+func (m *Manager) writeSearchSegment(ctx context.Context, id string, index searchIndex) (searchSegment, error) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return searchSegment{}, fmt.Errorf("failed to marshal search index: %w", err)
+	}
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		return searchSegment{}, fmt.Errorf("failed to name search index segment: %w", err)
+	}
+
+	file := searchIndexFolder(id) + hex.EncodeToString(name)
+	if err := m.store.WriteFile(ctx, file, data); err != nil {
+		return searchSegment{}, fmt.Errorf("failed to write search index: %w", err)
+	}
+	return searchSegment{file: file, index: index}, nil
+}
+
+// readSearchSegments reads the segments of the search index of a dataset. Segments that are
+// deleted while they are read, because they were merged, are skipped.
+func (m *Manager) readSearchSegments(ctx context.Context, id string) ([]searchSegment, error) {
+	files, err := m.store.ListFiles(ctx, searchIndexFolder(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list search index segments: %w", err)
+	}
+
+	var segments []searchSegment
+	for _, file := range append(files, searchIndexFile(id)) {
+		data, err := m.store.ReadFile(ctx, file)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read search index: %w", err)
+		}
+
+		segment := searchSegment{file: file, index: newSearchIndex()}
+		if err = json.Unmarshal(data, &segment.index); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal search index: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// readSearchIndex reads the whole search index of a dataset.
+func (m *Manager) readSearchIndex(ctx context.Context, id string) (searchIndex, error) {
+	segments, err := m.readSearchSegments(ctx, id)
+	if err != nil {
+		return searchIndex{}, err
+	}
+
+	index := newSearchIndex()
+	for _, segment := range segments {
+		index.merge(segment.index, func(string) bool { return true })
+	}
+	return index, nil
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
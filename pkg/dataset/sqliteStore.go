@@ -0,0 +1,374 @@
+package dataset
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	// Pure Go SQLite driver, so that the store works with CGO_ENABLED=0.
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS files (
+	name     TEXT PRIMARY KEY,
+	contents BLOB NOT NULL,
+	modified INTEGER NOT NULL DEFAULT 0
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS datasets (
+	id       TEXT PRIMARY KEY,
+	revision INTEGER NOT NULL,
+	record   BLOB NOT NULL
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS elements (
+	dataset_id TEXT NOT NULL,
+	-- The key of the element (see Manager.elementKey), which is only its name if it isn't encrypted.
+	name       TEXT NOT NULL,
+	position   INTEGER NOT NULL,
+	record     BLOB NOT NULL,
+	PRIMARY KEY (dataset_id, name)
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS elements_by_position ON elements (dataset_id, position);`
+
+// sqliteDirEnvVar is the directory that NewManager keeps a SQLite database per workspace in,
+// instead of storing datasets in the workspace itself.
+const sqliteDirEnvVar = "GPTSCRIPT_DATASETS_SQLITE_DIR"
+
+var (
+	sqliteStoresLock sync.Mutex
+	// sqliteStores holds the databases that have been opened by sqliteStoreForWorkspace, so that
+	// every request for a workspace shares one connection pool.
+	sqliteStores = make(map[string]*SQLiteStore)
+)
+
+// SQLiteStore stores dataset files as rows in a SQLite database, one row per file. Datasets and
+// their elements are stored in tables of their own (see recordStore), so that saving a dataset
+// only writes the elements that changed, and single elements can be read without the rest.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// sqliteStoreForWorkspace returns the store for the workspace's database in the directory. The
+// database is named after a hash of the workspace ID, and is only opened once per process.
+func sqliteStoreForWorkspace(dir, workspaceID string) (*SQLiteStore, error) {
+	path := filepath.Join(dir, workspaceKey(workspaceID)+".db")
+
+	sqliteStoresLock.Lock()
+	defer sqliteStoresLock.Unlock()
+
+	if s, ok := sqliteStores[path]; ok {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create sqlite directory %s: %w", dir, err)
+	}
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sqliteStores[path] = s
+	return s, nil
+}
+
+// NewSQLiteStore opens (creating it if needed) the SQLite database at the given path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	// Databases created before files had a modification time are missing the column. Their files
+	// are treated as written long ago.
+	if _, err := db.Exec(`SELECT modified FROM files LIMIT 0`); err != nil {
+		if _, err := db.Exec(`ALTER TABLE files ADD COLUMN modified INTEGER NOT NULL DEFAULT 0`); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to add modification times to sqlite schema: %w", err)
+		}
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	if err := s.db.QueryRowContext(ctx, `SELECT contents FROM files WHERE name = ?`, name).Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SQLiteStore) WriteFile(ctx context.Context, name string, contents []byte) error {
+	if contents == nil {
+		contents = []byte{}
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO files (name, contents, modified) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET contents = excluded.contents, modified = excluded.modified`,
+		name, contents, time.Now().UnixNano())
+	return err
+}
+
+func (s *SQLiteStore) DeleteFile(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM files WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	// Use a range over the primary key rather than LIKE, so that the lookup is indexed.
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM files WHERE name >= ? AND name < ? ORDER BY name`, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+
+	return files, rows.Err()
+}
+
+func (s *SQLiteStore) modTime(ctx context.Context, name string) (time.Time, error) {
+	var modified int64
+	if err := s.db.QueryRowContext(ctx, `SELECT modified FROM files WHERE name = ?`, name).Scan(&modified); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, modified), nil
+}
+
+func (s *SQLiteStore) readDatasetRecords(ctx context.Context, id string) (datasetRecord, []elementRecord, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return datasetRecord{}, nil, err
+	}
+	defer tx.Rollback()
+
+	d, err := readDatasetRow(ctx, tx, id)
+	if err != nil {
+		return datasetRecord{}, nil, err
+	}
+
+	elements, err := readElementRows(ctx, tx, id, 0, 0)
+	if err != nil {
+		return datasetRecord{}, nil, err
+	}
+
+	return d, elements, nil
+}
+
+func (s *SQLiteStore) listDatasetRecords(ctx context.Context) ([]datasetRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, revision, record FROM datasets ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var datasets []datasetRecord
+	for rows.Next() {
+		var d datasetRecord
+		if err := rows.Scan(&d.ID, &d.Revision, &d.Data); err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, d)
+	}
+
+	return datasets, rows.Err()
+}
+
+func (s *SQLiteStore) readElementRecord(ctx context.Context, id, key string) (elementRecord, bool, error) {
+	// Join with the dataset, so that a missing dataset can be told apart from a missing element.
+	var (
+		position sql.NullInt64
+		data     []byte
+	)
+	if err := s.db.QueryRowContext(ctx, `SELECT e.position, e.record FROM datasets d
+		LEFT JOIN elements e ON e.dataset_id = d.id AND e.name = ?
+		WHERE d.id = ?`, key, id).Scan(&position, &data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return elementRecord{}, false, fmt.Errorf("%s: %w", id, ErrNotFound)
+		}
+		return elementRecord{}, false, err
+	}
+
+	if !position.Valid {
+		return elementRecord{}, false, nil
+	}
+	return elementRecord{Key: key, Position: int(position.Int64), Data: data}, true, nil
+}
+
+func (s *SQLiteStore) readElementRecords(ctx context.Context, id string, offset, limit int) (int, []elementRecord, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := readDatasetRow(ctx, tx, id); err != nil {
+		return 0, nil, err
+	}
+
+	var total int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM elements WHERE dataset_id = ?`, id).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	elements, err := readElementRows(ctx, tx, id, offset, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, elements, nil
+}
+
+func (s *SQLiteStore) writeRecords(ctx context.Context, d datasetRecord, previousRevision int, changed []elementRecord, removed []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The revision check and the writes happen in one transaction, so concurrent saves can't both succeed.
+	var result sql.Result
+	if previousRevision == 0 {
+		result, err = tx.ExecContext(ctx, `INSERT INTO datasets (id, revision, record) VALUES (?, ?, ?)
+			ON CONFLICT (id) DO NOTHING`, d.ID, d.Revision, d.Data)
+	} else {
+		result, err = tx.ExecContext(ctx, `UPDATE datasets SET revision = ?, record = ? WHERE id = ? AND revision = ?`,
+			d.Revision, d.Data, d.ID, previousRevision)
+	}
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+
+	for _, key := range removed {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM elements WHERE dataset_id = ? AND name = ?`, d.ID, key); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range changed {
+		if e.Data == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE elements SET position = ? WHERE dataset_id = ? AND name = ?`, e.Position, d.ID, e.Key)
+		} else {
+			_, err = tx.ExecContext(ctx, `INSERT INTO elements (dataset_id, name, position, record) VALUES (?, ?, ?, ?)
+				ON CONFLICT (dataset_id, name) DO UPDATE SET position = excluded.position, record = excluded.record`,
+				d.ID, e.Key, e.Position, e.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) deleteRecords(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM datasets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s: %w", id, ErrNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM elements WHERE dataset_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func readDatasetRow(ctx context.Context, tx *sql.Tx, id string) (datasetRecord, error) {
+	d := datasetRecord{ID: id}
+	if err := tx.QueryRowContext(ctx, `SELECT revision, record FROM datasets WHERE id = ?`, id).Scan(&d.Revision, &d.Data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return datasetRecord{}, fmt.Errorf("%s: %w", id, ErrNotFound)
+		}
+		return datasetRecord{}, err
+	}
+
+	return d, nil
+}
+
+// readElementRows returns up to limit elements of the dataset, starting at position offset. A limit
+// of 0 means no limit.
+func readElementRows(ctx context.Context, tx *sql.Tx, id string, offset, limit int) ([]elementRecord, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT name, position, record FROM elements WHERE dataset_id = ?
+		ORDER BY position LIMIT ? OFFSET ?`, id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var elements []elementRecord
+	for rows.Next() {
+		var e elementRecord
+		if err := rows.Scan(&e.Key, &e.Position, &e.Data); err != nil {
+			return nil, err
+		}
+		elements = append(elements, e)
+	}
+
+	return elements, rows.Err()
+}
@@ -0,0 +1,18 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by a Store when the requested file does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Store is the storage backend that a Manager reads and writes dataset files through.
+// File names are slash-separated paths relative to the root of the store.
+type Store interface {
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	WriteFile(ctx context.Context, name string, contents []byte) error
+	DeleteFile(ctx context.Context, name string) error
+	ListFiles(ctx context.Context, prefix string) ([]string, error)
+}
@@ -0,0 +1,355 @@
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, NewLocalStore(t.TempDir()))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+// testStore runs the checks that every Store implementation must pass.
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := s.ReadFile(ctx, "datasets/missing.gds")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, s.DeleteFile(ctx, "datasets/missing.gds"), ErrNotFound)
+
+	files, err := s.ListFiles(ctx, datasetFolder)
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	require.NoError(t, s.WriteFile(ctx, "datasets/one.gds", []byte("one")))
+	require.NoError(t, s.WriteFile(ctx, "datasets/two.gds", []byte("two")))
+	require.NoError(t, s.WriteFile(ctx, "other/three.gds", []byte("three")))
+
+	data, err := s.ReadFile(ctx, "datasets/one.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), data)
+
+	// Overwriting a file replaces its contents.
+	require.NoError(t, s.WriteFile(ctx, "datasets/one.gds", []byte("uno")))
+	data, err = s.ReadFile(ctx, "datasets/one.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("uno"), data)
+
+	files, err = s.ListFiles(ctx, datasetFolder)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"datasets/one.gds", "datasets/two.gds"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "datasets/one.gds"))
+	_, err = s.ReadFile(ctx, "datasets/one.gds")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	files, err = s.ListFiles(ctx, datasetFolder)
+	require.NoError(t, err)
+	require.Equal(t, []string{"datasets/two.gds"}, files)
+
+	if c, ok := s.(conditionalStore); ok {
+		testConditionalStore(t, c)
+	}
+	if m, ok := s.(modTimeStore); ok {
+		testModTimeStore(t, m)
+	}
+}
+
+// testModTimeStore checks that a store reports when a file was last written.
+func testModTimeStore(t *testing.T, s modTimeStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := s.modTime(ctx, "datasets/missing.gds")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Some stores only keep modification times to the second.
+	modified, err := s.modTime(ctx, "datasets/two.gds")
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), modified, time.Minute)
+}
+
+// testConditionalStore checks that a file is only written if it hasn't changed since it was read.
+func testConditionalStore(t *testing.T, s conditionalStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, _, err := s.readFileVersion(ctx, "datasets/four.gds")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, s.writeFileIfVersion(ctx, "datasets/four.gds", []byte("four"), "missing"), ErrConflict)
+
+	// An empty version creates the file, but only once.
+	require.NoError(t, s.writeFileIfVersion(ctx, "datasets/four.gds", []byte("four"), ""))
+	require.ErrorIs(t, s.writeFileIfVersion(ctx, "datasets/four.gds", []byte("vier"), ""), ErrConflict)
+
+	data, version, err := s.readFileVersion(ctx, "datasets/four.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("four"), data)
+
+	require.NoError(t, s.writeFileIfVersion(ctx, "datasets/four.gds", []byte("quatre"), version))
+	require.ErrorIs(t, s.writeFileIfVersion(ctx, "datasets/four.gds", []byte("vier"), version), ErrConflict)
+
+	data, _, err = s.readFileVersion(ctx, "datasets/four.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("quatre"), data)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "datasets.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	testStore(t, s)
+}
+
+func TestS3Store(t *testing.T) {
+	srv := httptest.NewServer(newFakeS3("datasets-bucket"))
+	t.Cleanup(srv.Close)
+
+	s, err := NewS3Store(S3StoreOptions{
+		Endpoint:        strings.TrimPrefix(srv.URL, "http://"),
+		Bucket:          "datasets-bucket",
+		Prefix:          "workspace/",
+		Region:          "us-east-1",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		Insecure:        true,
+	})
+	require.NoError(t, err)
+
+	testStore(t, s)
+}
+
+// fakeS3 is a minimal, path-style S3 API that keeps objects in memory. It implements just
+// enough of the API for S3Store.
+type fakeS3 struct {
+	bucket  string
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: make(map[string][]byte)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			f.error(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+
+		type object struct {
+			Key  string
+			Size int
+		}
+		result := struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Name     string
+			Prefix   string
+			KeyCount int
+			Contents []object
+		}{Name: f.bucket, Prefix: r.URL.Query().Get("prefix")}
+
+		for k, v := range f.objects {
+			if strings.HasPrefix(k, result.Prefix) {
+				result.Contents = append(result.Contents, object{Key: k, Size: len(v)})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool {
+			return result.Contents[i].Key < result.Contents[j].Key
+		})
+		result.KeyCount = len(result.Contents)
+
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeAWSChunked(data)
+		}
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		existing, exists := f.objects[key]
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || (match != "*" && match != etag(existing))) {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		} else if r.Header.Get("If-None-Match") == "*" && exists {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		f.objects[key] = data
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		data, exists := f.objects[key]
+		if !exists {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+	}{Code: code})
+}
+
+// decodeAWSChunked strips the chunk framing that S3 clients use for streaming signed uploads.
+func decodeAWSChunked(data []byte) ([]byte, error) {
+	var out []byte
+	for {
+		header, rest, ok := bytes.Cut(data, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("malformed chunk header")
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || int64(len(rest)) < size {
+			return nil, fmt.Errorf("malformed chunk size %q", sizeHex)
+		}
+		if size == 0 {
+			return out, nil
+		}
+		out = append(out, rest[:size]...)
+		data = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+func TestCompressedStore(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryStore()
+	s := NewCompressedStore(inner)
+
+	testStore(t, s)
+
+	// Compressible files are stored compressed.
+	text := bytes.Repeat([]byte("the same words over and over "), 100)
+	require.NoError(t, s.WriteFile(ctx, "datasets/text", text))
+	stored, err := inner.ReadFile(ctx, "datasets/text")
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(stored, gzipHeader))
+	require.Less(t, len(stored), len(text))
+
+	data, err := s.ReadFile(ctx, "datasets/text")
+	require.NoError(t, err)
+	require.Equal(t, text, data)
+
+	// Files written without compression are read as is.
+	require.NoError(t, inner.WriteFile(ctx, "datasets/legacy.gds", []byte(`{"id":"gds://abc12"}`)))
+	data, err = s.ReadFile(ctx, "datasets/legacy.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"id":"gds://abc12"}`), data)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	oldKeys, err := NewStaticKeyProvider(oldKey)
+	require.NoError(t, err)
+
+	inner := NewMemoryStore()
+	s := NewEncryptedStore(inner, oldKeys)
+	testStore(t, s)
+
+	require.NoError(t, s.WriteFile(ctx, "datasets/secret.gds", []byte("customer data")))
+	require.NoError(t, inner.WriteFile(ctx, "datasets/legacy.gds", []byte("plain data")))
+
+	stored, err := inner.ReadFile(ctx, "datasets/secret.gds")
+	require.NoError(t, err)
+	require.NotContains(t, string(stored), "customer data")
+
+	// Files can't be read without the key, or when they are moved to another name.
+	_, err = NewEncryptedStore(inner, nil).ReadFile(ctx, "datasets/secret.gds")
+	require.ErrorContains(t, err, "no encryption key is configured")
+
+	require.NoError(t, inner.WriteFile(ctx, "datasets/moved.gds", stored))
+	_, err = s.ReadFile(ctx, "datasets/moved.gds")
+	require.Error(t, err)
+	require.NoError(t, inner.DeleteFile(ctx, "datasets/moved.gds"))
+
+	// Rotate to the new key, keeping the old one around to read the existing files.
+	rotatedKeys, err := NewStaticKeyProvider(newKey, oldKey)
+	require.NoError(t, err)
+	require.NoError(t, NewEncryptedStore(inner, rotatedKeys).RotateKeys(ctx, datasetFolder+"/"))
+
+	// The files are readable with only the new key now, including the one that wasn't encrypted.
+	newKeys, err := NewStaticKeyProvider(newKey)
+	require.NoError(t, err)
+	s = NewEncryptedStore(inner, newKeys)
+
+	data, err := s.ReadFile(ctx, "datasets/secret.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("customer data"), data)
+
+	stored, err = inner.ReadFile(ctx, "datasets/legacy.gds")
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(stored, encryptionHeader))
+
+	data, err = s.ReadFile(ctx, "datasets/legacy.gds")
+	require.NoError(t, err)
+	require.Equal(t, []byte("plain data"), data)
+
+	_, err = NewEncryptedStore(inner, oldKeys).ReadFile(ctx, "datasets/secret.gds")
+	require.Error(t, err)
+}
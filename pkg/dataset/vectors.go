@@ -0,0 +1,110 @@
+package dataset
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// vectorsFile held the embeddings of a dataset's elements before version 4 of the file format,
+// keyed by the hash of the element's blob. Embeddings are stored with each element now.
+func vectorsFile(id string) string {
+	return datasetFolder + "/" + strings.TrimPrefix(id, "gds://") + ".vec"
+}
+
+// NearestNeighbors returns up to k elements whose embeddings are most similar to the vector, by
+// cosine similarity, most similar first. Elements without an embedding are skipped.
+func (d *Dataset) NearestNeighbors(_ context.Context, vector []float32, k int) ([]SearchResult, error) {
+	if len(vector) == 0 {
+		return nil, errors.New("vector is required")
+	}
+
+	var results []SearchResult
+	for _, element := range d.sortedElements() {
+		if len(element.Embedding) == 0 {
+			continue
+		}
+		if len(element.Embedding) != len(vector) {
+			return nil, fmt.Errorf("vector has %d dimensions, but the embeddings in dataset %s have %d", len(vector), d.ID, len(element.Embedding))
+		}
+
+		results = append(results, SearchResult{
+			ElementMeta: element.ElementMeta,
+			Score:       cosineSimilarity(vector, element.Embedding),
+		})
+	}
+
+	// A stable sort keeps elements with the same similarity in order.
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// checkEmbedding returns an error if the element has an embedding with a different number of
+// dimensions than the embeddings of the other elements in the dataset.
+func (d *Dataset) checkEmbedding(e Element) error {
+	if len(e.Embedding) == 0 {
+		return nil
+	}
+
+	for name, other := range d.Elements {
+		if name == e.Name || len(other.Embedding) == 0 {
+			continue
+		}
+		if len(other.Embedding) != len(e.Embedding) {
+			return fmt.Errorf("embedding of element %s has %d dimensions, but the other embeddings in dataset %s have %d", e.Name, len(e.Embedding), d.ID, len(other.Embedding))
+		}
+		break
+	}
+	return nil
+}
+
+// readLegacyVectors sets the embeddings of the elements of a dataset that was saved before version
+// 4 of the file format from its vectors file. Elements that had the same contents shared an
+// embedding in that file, so they all get the embedding that was stored last.
+func (d *Dataset) readLegacyVectors(ctx context.Context) error {
+	data, err := d.m.store.ReadFile(ctx, vectorsFile(d.ID))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read embeddings: %w", err)
+	}
+
+	vectors := make(map[string][]float32)
+	if err = json.Unmarshal(data, &vectors); err != nil {
+		return fmt.Errorf("failed to unmarshal embeddings: %w", err)
+	}
+
+	for name, element := range d.Elements {
+		if embedding, ok := vectors[element.blob]; ok && element.blob != "" {
+			element.Embedding = embedding
+			d.Elements[name] = element
+		}
+	}
+
+	d.legacyVectors = true
+	return nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,66 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gptscript-ai/go-gptscript"
+)
+
+// WorkspaceStore stores dataset files in a GPTScript workspace.
+type WorkspaceStore struct {
+	gptscriptClient *gptscript.GPTScript
+	workspaceID     string
+}
+
+func NewWorkspaceStore(workspaceID string) (*WorkspaceStore, error) {
+	g, err := gptscript.NewGPTScript()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GPTScript: %w", err)
+	}
+
+	return &WorkspaceStore{gptscriptClient: g, workspaceID: workspaceID}, nil
+}
+
+func (s *WorkspaceStore) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.gptscriptClient.ReadFileInWorkspace(ctx, name, gptscript.ReadFileInWorkspaceOptions{
+		WorkspaceID: s.workspaceID,
+	})
+	if err != nil {
+		if isNotFoundInWorkspaceError(err) {
+			return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *WorkspaceStore) WriteFile(ctx context.Context, name string, contents []byte) error {
+	return s.gptscriptClient.WriteFileInWorkspace(ctx, name, contents, gptscript.WriteFileInWorkspaceOptions{
+		WorkspaceID: s.workspaceID,
+	})
+}
+
+func (s *WorkspaceStore) DeleteFile(ctx context.Context, name string) error {
+	err := s.gptscriptClient.DeleteFileInWorkspace(ctx, name, gptscript.DeleteFileInWorkspaceOptions{
+		WorkspaceID: s.workspaceID,
+	})
+	if err != nil && isNotFoundInWorkspaceError(err) {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return err
+}
+
+func (s *WorkspaceStore) ListFiles(ctx context.Context, prefix string) ([]string, error) {
+	return s.gptscriptClient.ListFilesInWorkspace(ctx, gptscript.ListFilesInWorkspaceOptions{
+		Prefix:      prefix,
+		WorkspaceID: s.workspaceID,
+	})
+}
+
+func isNotFoundInWorkspaceError(err error) bool {
+	var notFoundErr *gptscript.NotFoundInWorkspaceError
+	return errors.As(err, &notFoundErr)
+}
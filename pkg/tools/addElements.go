@@ -2,18 +2,20 @@ package tools
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strings"
 
 	"github.com/gptscript-ai/datasets/pkg/dataset"
 	"github.com/gptscript-ai/datasets/pkg/util"
 )
 
 type addElementsRequest struct {
-	DatasetID   string            `json:"datasetID"`
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Elements    []dataset.Element `json:"elements"`
+	DatasetID   string        `json:"datasetID"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Labels      selectorParam `json:"labels"`
+	Schema      schemaParam   `json:"schema"`
+	Elements    elementsParam `json:"elements"`
 }
 
 func AddElements(w http.ResponseWriter, r *http.Request) {
@@ -39,35 +41,47 @@ func AddElements(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	m.SetSource(util.GetSource(r))
 
-	var d dataset.Dataset
+	// Like the name and description, labels and the schema are only set on new datasets.
+	created := false
 	if req.DatasetID == "" {
-		d, err = m.NewDataset(r.Context(), req.Name, req.Description)
+		d, err := m.NewDataset(r.Context(), req.Name, req.Description)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	} else {
-		d, err = m.GetDataset(r.Context(), req.DatasetID)
-		if err != nil {
-			if strings.Contains(err.Error(), "not found") {
-				http.Error(w, "dataset not found", http.StatusNotFound)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		req.DatasetID = d.ID
+		created = true
 	}
 
-	for _, element := range req.Elements {
-		if err := d.AddElement(element); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+	d, ok := saveWithRetry(w, r, m, req.DatasetID, func(d *dataset.Dataset) error {
+		if created {
+			for key, value := range req.Labels {
+				d.SetLabel(key, value)
+			}
+			if len(req.Schema) > 0 && !req.Schema.isNull() {
+				if err := d.SetSchema(r.Context(), json.RawMessage(req.Schema)); err != nil {
+					return err
+				}
+			}
 		}
-	}
 
-	if err := d.Save(r.Context()); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// Report every element that can't be added, so that they can all be fixed at once.
+		var errs []error
+		for _, element := range req.Elements {
+			if err := d.AddElement(element); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+	if !ok {
+		// Don't leave an empty dataset behind if its schema or elements are rejected. The error
+		// has already been written, and the dataset is deleted on a best-effort basis.
+		if created {
+			_ = m.DeleteDataset(r.Context(), req.DatasetID)
+		}
 		return
 	}
 
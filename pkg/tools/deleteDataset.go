@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type deleteDatasetRequest struct {
+	DatasetID string `json:"datasetID"`
+}
+
+func DeleteDataset(w http.ResponseWriter, r *http.Request) {
+	var req deleteDatasetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.DeleteDataset(r.Context(), req.DatasetID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "dataset not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to delete dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err = w.Write([]byte(fmt.Sprintf("Deleted dataset %s", req.DatasetID))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -10,7 +10,9 @@ import (
 )
 
 type getAllElementsRequest struct {
-	DatasetID string `json:"datasetID"`
+	DatasetID string   `json:"datasetID"`
+	Offset    intParam `json:"offset"`
+	Limit     intParam `json:"limit"`
 }
 
 func GetAllElements(w http.ResponseWriter, r *http.Request) {
@@ -23,6 +25,9 @@ func GetAllElements(w http.ResponseWriter, r *http.Request) {
 	if req.DatasetID == "" {
 		http.Error(w, "datasetID is required", http.StatusBadRequest)
 		return
+	} else if req.Offset < 0 || req.Limit < 0 {
+		http.Error(w, "offset and limit must not be negative", http.StatusBadRequest)
+		return
 	}
 
 	workspaceID, err := util.GetWorkspaceID(r)
@@ -47,9 +52,13 @@ func GetAllElements(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	elements := d.GetAllElements()
+	elements, err := d.GetElementsPage(r.Context(), int(req.Offset), int(req.Limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	if err := json.NewEncoder(w).Encode(elements); err != nil {
+	if err := json.NewEncoder(w).Encode(newElementsPage(elements, d.GetLength(), int(req.Offset))); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gptscript-ai/datasets/pkg/dataset"
 	"github.com/gptscript-ai/datasets/pkg/util"
@@ -12,6 +13,8 @@ import (
 type getElementRequest struct {
 	DatasetID string `json:"datasetID"`
 	Name      string `json:"name"`
+	// Raw returns the contents of the element as they are, with their content type, instead of JSON.
+	Raw boolParam `json:"raw"`
 }
 
 func GetElement(w http.ResponseWriter, r *http.Request) {
@@ -41,15 +44,26 @@ func GetElement(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := m.GetDataset(r.Context(), req.DatasetID)
+	element, err := m.GetElement(r.Context(), req.DatasetID, req.Name)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get dataset: %v\n", err), http.StatusInternalServerError)
+		if strings.Contains(err.Error(), "dataset "+req.DatasetID+" not found") {
+			http.Error(w, "dataset not found", http.StatusNotFound)
+			return
+		} else if strings.Contains(err.Error(), "element "+req.Name+" not found") {
+			http.Error(w, "element not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to get element: %v\n", err), http.StatusInternalServerError)
 		return
 	}
 
-	element, err := d.GetElement(req.Name)
-	if err != nil {
-		http.Error(w, "element not found", http.StatusNotFound)
+	if req.Raw {
+		w.Header().Set("Content-Type", element.GetContentType())
+		if len(element.BinaryContents) > 0 {
+			_, _ = w.Write(element.BinaryContents)
+		} else {
+			_, _ = w.Write([]byte(element.Contents))
+		}
 		return
 	}
 
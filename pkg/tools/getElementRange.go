@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type getElementRangeRequest struct {
+	DatasetID string   `json:"datasetID"`
+	Start     intParam `json:"start"`
+	End       intParam `json:"end"`
+}
+
+// indexedElement is an element along with its position, which is included even if it is 0, unlike
+// in dataset.Element.
+type indexedElement struct {
+	Index                  int `json:"index"`
+	dataset.ElementNoIndex `json:",inline"`
+}
+
+func GetElementRange(w http.ResponseWriter, r *http.Request) {
+	var req getElementRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	d, err := m.GetDataset(r.Context(), req.DatasetID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "dataset not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to get dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	elements, err := d.GetElementsByRange(r.Context(), int(req.Start), int(req.End))
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid element range") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Embeddings are only meant for nearest neighbor searches, and would crowd out the contents.
+	indexed := make([]indexedElement, 0, len(elements))
+	for _, element := range elements {
+		indexed = append(indexed, indexedElement{
+			Index: element.Index,
+			ElementNoIndex: dataset.ElementNoIndex{
+				ElementMeta:    element.ElementMeta,
+				Contents:       element.Contents,
+				BinaryContents: element.BinaryContents,
+			},
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(newElementsPage(indexed, d.GetLength(), int(req.Start))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/stretchr/testify/require"
+)
+
+// setupWorkspace stores the datasets of the handlers in a SQLite database in a temporary directory,
+// rather than in a GPTScript workspace.
+func setupWorkspace(t *testing.T) {
+	t.Helper()
+	t.Setenv("GPTSCRIPT_DATASETS_SQLITE_DIR", t.TempDir())
+}
+
+// newRequest returns a tool call with the request body from the test workspace.
+func newRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Add("X-GPTScript-Env", "GPTSCRIPT_WORKSPACE_ID="+workspaceID(t))
+	return r
+}
+
+func workspaceID(t *testing.T) string {
+	return "directory://" + t.Name()
+}
+
+// call runs the handler with the request body as a tool call from the test workspace, and returns
+// the response.
+func call(t *testing.T, handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, newRequest(t, body))
+	return w
+}
+
+func TestGetElementRange(t *testing.T) {
+	setupWorkspace(t)
+
+	w := call(t, AddElements, `{"elements":[{"name":"a","contents":"aaa","embedding":[1]},{"name":"b","contents":"bbb"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	id := w.Body.String()
+
+	w = call(t, GetElementRange, `{"datasetID":"`+id+`","start":"0","end":"1"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var page struct {
+		Total    int              `json:"total"`
+		Elements []map[string]any `json:"elements"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Equal(t, 2, page.Total)
+	require.Len(t, page.Elements, 2)
+
+	// The first element has an index too, and embeddings are left out.
+	for i, element := range page.Elements {
+		require.Equal(t, float64(i), element["index"])
+		require.NotContains(t, element, "embedding")
+	}
+	require.Equal(t, "a", page.Elements[0]["name"])
+	require.Equal(t, "bbb", page.Elements[1]["contents"])
+}
+
+func TestSaveWithRetry(t *testing.T) {
+	setupWorkspace(t)
+	ctx := context.Background()
+
+	m, err := dataset.NewManager(workspaceID(t))
+	require.NoError(t, err)
+	d, err := m.NewDataset(ctx, "", "")
+	require.NoError(t, err)
+
+	// The dataset is changed by someone else after every read, until it has been changed enough.
+	conflict := func(conflicts int) func(*dataset.Dataset) error {
+		attempts := 0
+		return func(d *dataset.Dataset) error {
+			if attempts++; attempts <= conflicts {
+				other, err := m.GetDataset(ctx, d.ID)
+				require.NoError(t, err)
+				require.NoError(t, other.AddElement(dataset.Element{ElementMeta: dataset.ElementMeta{Name: fmt.Sprintf("other %d/%d", attempts, conflicts)}}))
+				require.NoError(t, other.Save(ctx))
+			}
+			return d.AddElement(dataset.Element{ElementMeta: dataset.ElementMeta{Name: fmt.Sprintf("mine %d/%d", attempts, conflicts)}})
+		}
+	}
+
+	w := httptest.NewRecorder()
+	saved, ok := saveWithRetry(w, newRequest(t, ""), m, d.ID, conflict(maxSaveAttempts-1))
+	require.True(t, ok, w.Body.String())
+	require.Contains(t, saved.Elements, fmt.Sprintf("mine %d/%d", maxSaveAttempts, maxSaveAttempts-1))
+
+	w = httptest.NewRecorder()
+	_, ok = saveWithRetry(w, newRequest(t, ""), m, d.ID, conflict(maxSaveAttempts))
+	require.False(t, ok)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Contains(t, w.Body.String(), dataset.ErrConflict.Error())
+
+	d, err = m.GetDataset(ctx, d.ID)
+	require.NoError(t, err)
+	// None of the changes of the call that gave up were saved, only the conflicting ones.
+	require.Equal(t, 2*maxSaveAttempts, d.GetLength())
+
+	w = httptest.NewRecorder()
+	_, ok = saveWithRetry(w, newRequest(t, ""), m, "gds://00000", conflict(0))
+	require.False(t, ok)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAddElementsRejected(t *testing.T) {
+	setupWorkspace(t)
+
+	// A new dataset isn't left behind when its elements are rejected.
+	w := call(t, AddElements, `{"name":"numbers","schema":"{\"type\":\"number\"}","elements":[{"name":"a","contents":"1"},{"name":"b","contents":"two"}]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "element b")
+
+	m, err := dataset.NewManager(workspaceID(t))
+	require.NoError(t, err)
+	datasets, err := m.ListDatasets(context.Background(), nil)
+	require.NoError(t, err)
+	require.Empty(t, datasets)
+
+	// An existing dataset is kept.
+	w = call(t, AddElements, `{"name":"numbers","schema":{"type":"number"},"elements":[{"name":"a","contents":"1"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	id := w.Body.String()
+
+	w = call(t, AddElements, `{"datasetID":"`+id+`","elements":[{"name":"b","contents":"two"}]}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	d, err := m.GetDataset(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, 1, d.GetLength())
+}
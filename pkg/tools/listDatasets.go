@@ -2,14 +2,33 @@ package tools
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/gptscript-ai/datasets/pkg/dataset"
 	"github.com/gptscript-ai/datasets/pkg/util"
 )
 
+type listDatasetsRequest struct {
+	Labels selectorParam `json:"labels"`
+	Sort   string        `json:"sort"`
+}
+
 func ListDatasets(w http.ResponseWriter, r *http.Request) {
+	// The request body is optional, since all of its fields are.
+	var req listDatasetsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Sort != "" && req.Sort != "recent" {
+		http.Error(w, fmt.Sprintf("unknown sort order %q", req.Sort), http.StatusBadRequest)
+		return
+	}
+
 	workspaceID, err := util.GetWorkspaceID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -22,12 +41,16 @@ func ListDatasets(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	datasets, err := m.ListDatasets(r.Context())
+	datasets, err := m.ListDatasets(r.Context(), req.Labels)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to list datasets: %v\n", err), http.StatusInternalServerError)
 		return
 	}
 
+	if req.Sort == "recent" {
+		dataset.SortByRecency(datasets)
+	}
+
 	if err := json.NewEncoder(w).Encode(datasets); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
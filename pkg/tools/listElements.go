@@ -11,7 +11,9 @@ import (
 )
 
 type listElementsRequest struct {
-	DatasetID string `json:"datasetID"`
+	DatasetID string   `json:"datasetID"`
+	Offset    intParam `json:"offset"`
+	Limit     intParam `json:"limit"`
 }
 
 func ListElements(w http.ResponseWriter, r *http.Request) {
@@ -24,6 +26,9 @@ func ListElements(w http.ResponseWriter, r *http.Request) {
 	if req.DatasetID == "" {
 		http.Error(w, "datasetID is required", http.StatusBadRequest)
 		return
+	} else if req.Offset < 0 || req.Limit < 0 {
+		http.Error(w, "offset and limit must not be negative", http.StatusBadRequest)
+		return
 	}
 
 	workspaceID, err := util.GetWorkspaceID(r)
@@ -38,7 +43,7 @@ func ListElements(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d, err := m.GetDataset(r.Context(), req.DatasetID)
+	elements, total, err := m.ListElementsPage(r.Context(), req.DatasetID, int(req.Offset), int(req.Limit))
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			http.Error(w, "dataset not found", http.StatusNotFound)
@@ -48,7 +53,7 @@ func ListElements(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(d.ListElements()); err != nil {
+	if err := json.NewEncoder(w).Encode(newElementsPage(elements, total, int(req.Offset))); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
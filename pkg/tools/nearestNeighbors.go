@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type nearestNeighborsRequest struct {
+	DatasetID string      `json:"datasetID"`
+	Vector    vectorParam `json:"vector"`
+	Limit     intParam    `json:"limit"`
+}
+
+func NearestNeighbors(w http.ResponseWriter, r *http.Request) {
+	var req nearestNeighborsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	} else if len(req.Vector) == 0 {
+		http.Error(w, "vector is required", http.StatusBadRequest)
+		return
+	} else if req.Limit < 0 {
+		http.Error(w, "limit must not be negative", http.StatusBadRequest)
+		return
+	} else if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	d, err := m.GetDataset(r.Context(), req.DatasetID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "dataset not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to get dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	results, err := d.NearestNeighbors(r.Context(), req.Vector, int(req.Limit))
+	if err != nil {
+		if strings.Contains(err.Error(), "dimensions") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to search dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if results == nil {
+		results = []dataset.SearchResult{}
+	}
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
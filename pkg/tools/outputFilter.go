@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"os"
 	"regexp"
-	"sort"
 	"strings"
 
 	"github.com/gptscript-ai/datasets/pkg/dataset"
@@ -79,15 +78,22 @@ outerFor:
 			Length:      len(d.Elements),
 		}
 
-		var elementList []dataset.Element
-		for _, element := range d.Elements {
-			elementList = append(elementList, element)
-		}
-		sort.Slice(elementList, func(i, j int) bool {
-			return elementList[i].Index < elementList[j].Index
-		})
+		elementList := d.ListElements()
+		for _, meta := range elementList {
+			// Contents are only read as needed, so that elements past the budget are never loaded.
+			element, err := d.GetElement(r.Context(), meta.Name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			// Images, PDFs and other binary contents are no use to the LLM, so only their metadata is shown.
+			if element.ContentType = element.GetContentType(); !dataset.IsText(element.ContentType) {
+				element.Contents, element.BinaryContents = "", nil
+			}
+
+			element.Embedding = nil
 
-		for _, element := range elementList {
 			budget -= len(element.Contents)
 			budget -= len(element.BinaryContents)
 			if budget < 0 {
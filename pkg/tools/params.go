@@ -0,0 +1,219 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+)
+
+// intParam is an integer tool parameter. GPTScript passes tool parameters as strings, so both
+// strings and numbers are accepted. An empty string is zero.
+type intParam int
+
+func (p *intParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+
+	if s = strings.TrimSpace(s); s == "" || s == "null" {
+		*p = 0
+		return nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+
+	*p = intParam(i)
+	return nil
+}
+
+// boolParam is a boolean tool parameter. Like intParam, it accepts strings as well as booleans, and
+// an empty string is false.
+type boolParam bool
+
+func (p *boolParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+
+	if s = strings.TrimSpace(s); s == "" || s == "null" {
+		*p = false
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+
+	*p = boolParam(b)
+	return nil
+}
+
+// vectorParam is a vector tool parameter. It accepts a JSON array of numbers, or a string that
+// contains one. An empty string is no vector.
+type vectorParam []float32
+
+func (p *vectorParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if data = []byte(strings.TrimSpace(s)); len(data) == 0 {
+			*p = nil
+			return nil
+		}
+	}
+
+	var vector []float32
+	if err := json.Unmarshal(data, &vector); err != nil {
+		return fmt.Errorf("invalid vector: %w", err)
+	}
+
+	*p = vector
+	return nil
+}
+
+// schemaParam is a JSON Schema tool parameter. It accepts a JSON object, or a string that contains
+// one. null, or a string that contains it, means the schema should be removed.
+type schemaParam json.RawMessage
+
+func (p *schemaParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		data = []byte(strings.TrimSpace(s))
+	}
+
+	if len(data) == 0 {
+		*p = nil
+		return nil
+	} else if !json.Valid(data) {
+		return fmt.Errorf("invalid schema: %s", data)
+	}
+
+	*p = append(schemaParam(nil), data...)
+	return nil
+}
+
+// isNull reports whether the schema should be removed.
+func (p schemaParam) isNull() bool {
+	return string(p) == "null"
+}
+
+// selectorParam is a set of key/value pairs, such as labels or metadata. It accepts a JSON object,
+// a string that contains one, or a string of comma separated key=value pairs, like
+// "source=github,author=jane".
+type selectorParam map[string]string
+
+func (p *selectorParam) UnmarshalJSON(data []byte) error {
+	var selector map[string]string
+	if err := json.Unmarshal(data, &selector); err == nil {
+		*p = selector
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid selector: %s", data)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		if err := json.Unmarshal([]byte(s), &selector); err != nil {
+			return fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		*p = selector
+		return nil
+	}
+
+	selector = make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid selector %q, expected key=value pairs", s)
+		}
+		selector[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	*p = selector
+	return nil
+}
+
+// elementParam is an element tool parameter. It accepts a JSON object, or a string that contains
+// one. The metadata and embedding of the element are accepted the same way as selectorParam and
+// vectorParam.
+type elementParam dataset.Element
+
+func (p *elementParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		data = []byte(s)
+	}
+
+	// Metadata and Embedding shadow the fields of the embedded element.
+	var element struct {
+		dataset.Element
+		Metadata  selectorParam `json:"metadata"`
+		Embedding vectorParam   `json:"embedding"`
+	}
+	if err := json.Unmarshal(data, &element); err != nil {
+		return fmt.Errorf("invalid element: %w", err)
+	}
+
+	element.Element.Metadata = element.Metadata
+	element.Element.Embedding = element.Embedding
+	*p = elementParam(element.Element)
+	return nil
+}
+
+// elementsParam is a list of elements. It accepts a JSON array of elements, or a string that
+// contains one, and every element is accepted like elementParam.
+type elementsParam []dataset.Element
+
+func (p *elementsParam) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		data = []byte(s)
+	}
+
+	var elements []elementParam
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return fmt.Errorf("invalid elements: %w", err)
+	}
+
+	*p = make(elementsParam, 0, len(elements))
+	for _, element := range elements {
+		*p = append(*p, dataset.Element(element))
+	}
+	return nil
+}
+
+// elementsPage is the response for tools that return a page of the elements of a dataset.
+type elementsPage[T any] struct {
+	Total    int `json:"total"`
+	Elements []T `json:"elements"`
+	// NextOffset is the offset of the next page, or zero if this is the last page.
+	NextOffset int `json:"nextOffset,omitempty"`
+}
+
+func newElementsPage[T any](elements []T, total, offset int) elementsPage[T] {
+	p := elementsPage[T]{
+		Total:    total,
+		Elements: elements,
+	}
+	if elements == nil {
+		p.Elements = []T{}
+	}
+	if next := offset + len(elements); next < total && len(elements) > 0 {
+		p.NextOffset = next
+	}
+	return p
+}
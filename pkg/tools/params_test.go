@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElementParam(t *testing.T) {
+	expected := dataset.Element{
+		ElementMeta: dataset.ElementMeta{
+			Name:        "a",
+			Description: "the letter a",
+			Metadata:    map[string]string{"source": "github"},
+			ContentType: "text/plain",
+		},
+		Contents:  "aaa",
+		Embedding: []float32{1, 2},
+	}
+
+	for _, data := range []string{
+		`{"name":"a","description":"the letter a","contents":"aaa","contentType":"text/plain","metadata":{"source":"github"},"embedding":[1,2]}`,
+		`"{\"name\":\"a\",\"description\":\"the letter a\",\"contents\":\"aaa\",\"contentType\":\"text/plain\",\"metadata\":{\"source\":\"github\"},\"embedding\":[1,2]}"`,
+		`{"name":"a","description":"the letter a","contents":"aaa","contentType":"text/plain","metadata":"{\"source\":\"github\"}","embedding":"[1,2]"}`,
+		`{"name":"a","description":"the letter a","contents":"aaa","contentType":"text/plain","metadata":"source=github","embedding":"[1, 2]"}`,
+	} {
+		var p elementParam
+		require.NoError(t, json.Unmarshal([]byte(data), &p), data)
+		require.Equal(t, expected, dataset.Element(p), data)
+	}
+
+	var p elementParam
+	require.NoError(t, json.Unmarshal([]byte(`{"name":"b","metadata":"","embedding":""}`), &p))
+	require.Equal(t, dataset.Element{ElementMeta: dataset.ElementMeta{Name: "b", Metadata: map[string]string{}}}, dataset.Element(p))
+
+	require.Error(t, json.Unmarshal([]byte(`"not an element"`), &p))
+}
+
+func TestElementsParam(t *testing.T) {
+	for _, data := range []string{
+		`[{"name":"a","contents":"aaa"},"{\"name\":\"b\",\"embedding\":\"[1]\"}"]`,
+		`"[{\"name\":\"a\",\"contents\":\"aaa\"},{\"name\":\"b\",\"embedding\":[1]}]"`,
+	} {
+		var p elementsParam
+		require.NoError(t, json.Unmarshal([]byte(data), &p), data)
+		require.Equal(t, elementsParam{
+			{ElementMeta: dataset.ElementMeta{Name: "a"}, Contents: "aaa"},
+			{ElementMeta: dataset.ElementMeta{Name: "b"}, Embedding: []float32{1}},
+		}, p, data)
+	}
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type renameElementRequest struct {
+	DatasetID string `json:"datasetID"`
+	Name      string `json:"name"`
+	NewName   string `json:"newName"`
+}
+
+func RenameElement(w http.ResponseWriter, r *http.Request) {
+	var req renameElementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	} else if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	} else if req.NewName == "" {
+		http.Error(w, "newName is required", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := saveWithRetry(w, r, m, req.DatasetID, func(d *dataset.Dataset) error {
+		return d.RenameElement(req.Name, req.NewName)
+	}); !ok {
+		return
+	}
+
+	if _, err = w.Write([]byte(fmt.Sprintf("Renamed element %s to %s in dataset %s", req.Name, req.NewName, req.DatasetID))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type replaceElementRequest struct {
+	DatasetID string       `json:"datasetID"`
+	Element   elementParam `json:"element"`
+}
+
+func ReplaceElement(w http.ResponseWriter, r *http.Request) {
+	var req replaceElementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	} else if req.Element.Name == "" {
+		http.Error(w, "element name is required", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+	m.SetSource(util.GetSource(r))
+
+	if _, ok := saveWithRetry(w, r, m, req.DatasetID, func(d *dataset.Dataset) error {
+		return d.ReplaceElement(dataset.Element(req.Element))
+	}); !ok {
+		return
+	}
+
+	if _, err = w.Write([]byte(fmt.Sprintf("Replaced element %s in dataset %s", req.Element.Name, req.DatasetID))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,53 @@
+package tools
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+)
+
+// maxSaveAttempts is how many times a dataset is re-read and saved again after a conflicting change.
+const maxSaveAttempts = 5
+
+// saveWithRetry reads the dataset, applies update to it, and saves it. Another request can save the
+// dataset between reading and saving it here. When that happens, the dataset is read again and the
+// update is retried, so that neither request loses its changes. If it fails, the error has already
+// been written to w and false is returned.
+func saveWithRetry(w http.ResponseWriter, r *http.Request, m dataset.Manager, id string, update func(*dataset.Dataset) error) (dataset.Dataset, bool) {
+	for attempt := 1; ; attempt++ {
+		d, err := m.GetDataset(r.Context(), id)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				http.Error(w, "dataset not found", http.StatusNotFound)
+				return dataset.Dataset{}, false
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return dataset.Dataset{}, false
+		}
+
+		if err := update(&d); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return dataset.Dataset{}, false
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return dataset.Dataset{}, false
+		}
+
+		err = d.Save(r.Context())
+		if err == nil {
+			return d, true
+		} else if errors.Is(err, dataset.ErrConflict) {
+			if attempt < maxSaveAttempts {
+				continue
+			}
+			http.Error(w, err.Error(), http.StatusConflict)
+			return dataset.Dataset{}, false
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return dataset.Dataset{}, false
+	}
+}
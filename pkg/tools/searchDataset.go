@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+// defaultSearchLimit is the number of results returned when the request doesn't set a limit.
+const defaultSearchLimit = 10
+
+type searchDatasetRequest struct {
+	DatasetID string   `json:"datasetID"`
+	Query     string   `json:"query"`
+	Limit     intParam `json:"limit"`
+}
+
+func SearchDataset(w http.ResponseWriter, r *http.Request) {
+	var req searchDatasetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	} else if req.Query == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	} else if req.Limit < 0 {
+		http.Error(w, "limit must not be negative", http.StatusBadRequest)
+		return
+	} else if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	d, err := m.GetDataset(r.Context(), req.DatasetID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "dataset not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("failed to get dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	results, err := d.Search(r.Context(), req.Query, int(req.Limit))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to search dataset: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	if results == nil {
+		results = []dataset.SearchResult{}
+	}
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gptscript-ai/datasets/pkg/dataset"
+	"github.com/gptscript-ai/datasets/pkg/util"
+)
+
+type updateDatasetMetaRequest struct {
+	DatasetID   string        `json:"datasetID"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Labels      selectorParam `json:"labels"`
+	Schema      schemaParam   `json:"schema"`
+}
+
+func UpdateDatasetMeta(w http.ResponseWriter, r *http.Request) {
+	var req updateDatasetMetaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DatasetID == "" {
+		http.Error(w, "datasetID is required", http.StatusBadRequest)
+		return
+	} else if req.Name == "" && req.Description == "" && len(req.Labels) == 0 && len(req.Schema) == 0 {
+		http.Error(w, "name, description, labels, or schema is required", http.StatusBadRequest)
+		return
+	}
+
+	workspaceID, err := util.GetWorkspaceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := dataset.NewManager(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create dataset manager: %v\n", err), http.StatusInternalServerError)
+		return
+	}
+
+	d, ok := saveWithRetry(w, r, m, req.DatasetID, func(d *dataset.Dataset) error {
+		// Only the given fields are changed.
+		if req.Name != "" {
+			d.SetName(req.Name)
+		}
+		if req.Description != "" {
+			d.SetDescription(req.Description)
+		}
+		for key, value := range req.Labels {
+			if value == "" {
+				d.DeleteLabel(key)
+			} else {
+				d.SetLabel(key, value)
+			}
+		}
+		if req.Schema.isNull() {
+			return d.SetSchema(r.Context(), nil)
+		} else if len(req.Schema) > 0 {
+			return d.SetSchema(r.Context(), json.RawMessage(req.Schema))
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(d.DatasetMeta); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,63 @@
+package util
+
+import "sync"
+
+// KeyedRWMutex provides a separate read/write lock for every key. The zero value is ready to use.
+type KeyedRWMutex struct {
+	lock  sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// Lock locks the key for writing and returns the function that unlocks it.
+func (k *KeyedRWMutex) Lock(key string) func() {
+	l := k.acquire(key)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.release(key)
+	}
+}
+
+// RLock locks the key for reading and returns the function that unlocks it.
+func (k *KeyedRWMutex) RLock(key string) func() {
+	l := k.acquire(key)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		k.release(key)
+	}
+}
+
+func (k *KeyedRWMutex) acquire(key string) *keyedLock {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+
+	l, ok := k.locks[key]
+	if !ok {
+		l = new(keyedLock)
+		k.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+// release drops the reference to the lock for the key, and forgets the lock once it is unused.
+func (k *KeyedRWMutex) release(key string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if l := k.locks[key]; l != nil {
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedRWMutexReaders(t *testing.T) {
+	var k KeyedRWMutex
+
+	// Readers of the same key hold the lock at the same time.
+	const readers = 5
+	var (
+		inside  sync.WaitGroup
+		release = make(chan struct{})
+		done    sync.WaitGroup
+	)
+	inside.Add(readers)
+	done.Add(readers)
+	for range readers {
+		go func() {
+			defer done.Done()
+			unlock := k.RLock("a")
+			defer unlock()
+
+			inside.Done()
+			<-release
+		}()
+	}
+
+	waitFor(t, &inside)
+
+	// A writer waits for the readers.
+	locked := make(chan struct{})
+	go func() {
+		defer k.Lock("a")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("writer locked the key while it was read")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	done.Wait()
+	<-locked
+}
+
+func TestKeyedRWMutexWriters(t *testing.T) {
+	var k KeyedRWMutex
+
+	// Writers of the same key take turns, while another key is not blocked by them.
+	var (
+		holders, maxHolders atomic.Int32
+		wg                  sync.WaitGroup
+	)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer k.Lock("a")()
+
+			n := holders.Add(1)
+			for {
+				if m := maxHolders.Load(); n <= m || maxHolders.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+		}()
+	}
+
+	unlockB := k.Lock("b")
+	unlockC := k.Lock("c")
+	unlockB()
+	unlockC()
+
+	wg.Wait()
+	require.Equal(t, int32(1), maxHolders.Load())
+}
+
+func TestKeyedRWMutexCleanup(t *testing.T) {
+	var k KeyedRWMutex
+
+	unlockRead := k.RLock("a")
+	unlockOtherRead := k.RLock("a")
+	unlockWrite := k.Lock("b")
+	require.Len(t, k.locks, 2)
+	require.Equal(t, 2, k.locks["a"].refs)
+
+	// A key is forgotten once nothing holds or waits for its lock.
+	unlockRead()
+	require.Equal(t, 1, k.locks["a"].refs)
+	unlockOtherRead()
+	require.NotContains(t, k.locks, "a")
+
+	waiting := make(chan struct{})
+	go func() {
+		defer k.Lock("b")()
+		close(waiting)
+	}()
+	require.Eventually(t, func() bool {
+		k.lock.Lock()
+		defer k.lock.Unlock()
+		return k.locks["b"].refs == 2
+	}, time.Second, time.Millisecond)
+
+	unlockWrite()
+	<-waiting
+	require.Eventually(t, func() bool {
+		k.lock.Lock()
+		defer k.lock.Unlock()
+		return len(k.locks) == 0
+	}, time.Second, time.Millisecond)
+}
+
+// waitFor waits for the wait group, failing the test if it takes too long.
+func waitFor(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+}
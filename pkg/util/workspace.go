@@ -6,12 +6,28 @@ import (
 	"strings"
 )
 
-func GetWorkspaceID(r *http.Request) (string, error) {
+// GetEnv returns the value of the GPTScript environment variable that was passed in the request.
+func GetEnv(r *http.Request, name string) (string, bool) {
 	for _, kv := range r.Header.Values("X-GPTScript-Env") {
-		if value, ok := strings.CutPrefix(kv, "GPTSCRIPT_WORKSPACE_ID="); ok {
-			return value, nil
+		if value, ok := strings.CutPrefix(kv, name+"="); ok {
+			return value, true
 		}
 	}
+	return "", false
+}
+
+func GetWorkspaceID(r *http.Request) (string, error) {
+	if value, ok := GetEnv(r, "GPTSCRIPT_WORKSPACE_ID"); ok {
+		return value, nil
+	}
 
 	return "", fmt.Errorf("GPTSCRIPT_WORKSPACE_ID not found in environment header")
 }
+
+// GetSource returns the tool or program that made the request, from GPTSCRIPT_DATASETS_SOURCE.
+// GPTScript only passes the variables that the service tool requests in its metadata, so it is
+// listed in tool.gpt. It is empty if the caller didn't set it.
+func GetSource(r *http.Request) string {
+	source, _ := GetEnv(r, "GPTSCRIPT_DATASETS_SOURCE")
+	return source
+}